@@ -0,0 +1,750 @@
+// Package client is the Go client of the irc-glines-api /api2 endpoints.
+//
+//	c := client.New("http://127.0.0.1:2000", apiKey)
+//	glines, err := c.GlineLookup(ctx, "undernet", "1.2.3.4")
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GlineData is a gline as returned by the API.
+type GlineData struct {
+	Active           bool   `json:"active"`
+	Mask             string `json:"mask"`
+	ExpireTS         int64  `json:"expirets"`
+	LastModTS        int64  `json:"lastmodts"`
+	HoursUntilExpire int64  `json:"hoursuntilexpire"`
+	Reason           string `json:"reason"`
+	ID               string `json:"id"`
+	Setter           string `json:"setter,omitempty"`
+	// Fields parsed from the reason.
+	Auto   bool   `json:"auto,omitempty"`
+	Level  *int   `json:"level,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	Policy string `json:"policy,omitempty"`
+	Email  string `json:"email,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// From the GeoIP databases.
+	ASN         uint64       `json:"asn,omitempty"`
+	ASOrg       string       `json:"asorg,omitempty"`
+	Country     string       `json:"country,omitempty"`
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Explanation is the plain-language explanation of a gline.
+type Explanation struct {
+	Category    string `json:"category"`
+	Language    string `json:"language"`
+	Title       string `json:"title"`
+	Explanation string `json:"explanation"`
+	Removal     string `json:"removal"`
+	SelfRemoval bool   `json:"selfremoval"`
+}
+
+// Stats counts the active glines per ASN and per country.
+type Stats struct {
+	Glines    int           `json:"glines"`
+	ByASN     []*StatsEntry `json:"byasn"`
+	ByCountry []*StatsEntry `json:"bycountry"`
+}
+
+type StatsEntry struct {
+	ASN     uint64 `json:"asn,omitempty"`
+	ASOrg   string `json:"asorg,omitempty"`
+	Country string `json:"country,omitempty"`
+	Glines  int    `json:"glines"`
+}
+
+// Status is the state of the bot's connection to IRC: "connecting",
+// "registering", "connected", "waiting" (before the next attempt) or
+// "stopped".
+type Status struct {
+	State         string          `json:"state"`
+	Server        string          `json:"server"`
+	Since         int64           `json:"since"`
+	Attempts      int             `json:"attempts"` // failed since the last successful connection
+	LastError     string          `json:"lasterror,omitempty"`
+	NextAttemptTS int64           `json:"nextattemptts,omitempty"`
+	OperServ      *OperServStatus `json:"operserv,omitempty"`
+}
+
+// OperServStatus is the state of the bot's login to OperServ.
+type OperServStatus struct {
+	State     string `json:"state"` // unknown, pending, authenticated or failed
+	Since     int64  `json:"since"`
+	Queued    int    `json:"queued"` // commands waiting for the login to complete
+	LastReply string `json:"lastreply,omitempty"`
+}
+
+// Health is the health of a network, as returned by /api2/health. A
+// network is healthy while the bot is connected to it.
+type Health struct {
+	Network  string `json:"network"`
+	Healthy  bool   `json:"healthy"`
+	State    string `json:"state"`
+	Server   string `json:"server"`
+	OperServ string `json:"operserv"` // state of the OperServ login
+	LagMS    int64  `json:"lagms"`
+	AvgLagMS int64  `json:"avglagms"`
+	LastPong int64  `json:"lastpong,omitempty"`
+}
+
+// PendingRemoval is a gline removal waiting for the approval of a second
+// person, as configured in the API's approval rules.
+type PendingRemoval struct {
+	ID          int    `json:"id"`
+	Mask        string `json:"mask"`
+	Reason      string `json:"reason,omitempty"`
+	Rule        string `json:"rule"`        // name of the rule holding it
+	RequestedBy string `json:"requestedby"` // name of the API key
+	CreatedTS   int64  `json:"createdts"`
+	ExpireTS    int64  `json:"expirets"` // dropped if not approved by then
+}
+
+// ScheduledJob is a removal or modification of a gline to run at a given
+// time. Action is "remove" or "modify".
+type ScheduledJob struct {
+	ID          int    `json:"id"`
+	Action      string `json:"action"`
+	Mask        string `json:"mask"`
+	Duration    int64  `json:"duration,omitempty"` // modify: new duration, in seconds
+	Reason      string `json:"reason,omitempty"`
+	AtTS        int64  `json:"atts"`
+	CreatedTS   int64  `json:"createdts"`
+	RequestedBy string `json:"requestedby,omitempty"` // name of the API key
+}
+
+// JobRequest describes a job for Schedule. Either At or In is required.
+type JobRequest struct {
+	Action   string // "remove" (default) or "modify"
+	Mask     string
+	At       time.Time     // when to run the job
+	In       time.Duration // or how long from now
+	Duration time.Duration // modify: the new duration of the gline
+	// modify: the new reason, the current one if empty. remove: passed to
+	// the remover.
+	Reason string
+}
+
+func (r JobRequest) body() map[string]string {
+	body := map[string]string{"action": r.Action, "mask": r.Mask, "reason": r.Reason}
+	if !r.At.IsZero() {
+		body["at"] = strconv.FormatInt(r.At.Unix(), 10)
+	}
+	if r.In > 0 {
+		body["in"] = r.In.String()
+	}
+	if r.Duration > 0 {
+		body["duration"] = r.Duration.String()
+	}
+	return body
+}
+
+// DryRunResult says what a state-changing call made in dry-run mode would
+// have done.
+type DryRunResult struct {
+	DryRun bool `json:"dryrun"`
+	// IRC lines the call would have sent.
+	Lines []string `json:"lines"`
+	// Known glines the call would have affected.
+	Glines []*GlineData `json:"glines,omitempty"`
+	// Name of the approval rule that would have held the removal.
+	Approval string `json:"approval,omitempty"`
+	// Job that would have been scheduled or cancelled.
+	Job *ScheduledJob `json:"job,omitempty"`
+	// What the watchlist would hold. Omitted if it would be deleted.
+	Watchlist []string `json:"watchlist,omitempty"`
+}
+
+// AuditRecord is an entry of the audit log.
+type AuditRecord struct {
+	Time    int64  `json:"time"`
+	Network string `json:"network"`
+	Source  string `json:"source"` // "irc" for bot commands, "api" for API calls
+	Actor   string `json:"actor"`  // nick!user@host, or the IP of the API client
+	Account string `json:"account,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Command string `json:"command"` // the bot command, or the API endpoint
+	// "ok", "error", "dryrun", or "unauthorized" for API calls made with a
+	// missing or invalid key.
+	Outcome string `json:"outcome"`
+	// API calls only.
+	Key    string            `json:"key,omitempty"` // name of the API key used
+	Params map[string]string `json:"params,omitempty"`
+	Lines  []string          `json:"lines,omitempty"` // sent to IRC
+	Status int               `json:"status,omitempty"`
+}
+
+// AuditFilter selects records in Audit. Zero values match everything.
+type AuditFilter struct {
+	Key   string // name of the API key
+	Mask  string // matched against the target and the parameters, wildcards allowed
+	Since time.Time
+	Until time.Time
+}
+
+// Event is an entry of the event stream.
+type Event struct {
+	Type    string          `json:"type"`
+	Network string          `json:"network"`
+	TS      int64           `json:"ts"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Change is an entry of the change feed. Kind is "add", "modify" or
+// "expire".
+type Change struct {
+	Seq   uint64    `json:"seq"`
+	Kind  string    `json:"kind"`
+	TS    int64     `json:"ts"`
+	Gline GlineData `json:"gline"`
+}
+
+// Changes is a batch of the change feed. Pass Cursor to the next call, and
+// call again right away if More is true.
+type Changes struct {
+	Changes []Change `json:"changes"`
+	Cursor  uint64   `json:"cursor"`
+	More    bool     `json:"more"`
+}
+
+// ExportStream is the body of an export. Cursor is the change feed cursor
+// as of the start of the export: follow the feed from it to keep the
+// exported set up to date.
+type ExportStream struct {
+	io.ReadCloser
+	Cursor uint64
+}
+
+// Filter selects glines for Search and Export. Zero values match
+// everything.
+type Filter struct {
+	Active *bool
+	Setter string // wildcard mask
+	Reason string // regex, case-insensitive
+	Auto   *bool
+	Level  *int
+	IP     string // IP or CIDR containing the IP parsed from the reason
+	Policy string
+	Email  string // wildcard mask
+	URL    string // wildcard mask
+}
+
+func (f Filter) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if f.Active != nil {
+		v.Set("active", strconv.FormatBool(*f.Active))
+	}
+	if f.Auto != nil {
+		v.Set("auto", strconv.FormatBool(*f.Auto))
+	}
+	if f.Level != nil {
+		v.Set("level", strconv.Itoa(*f.Level))
+	}
+	set("setter", f.Setter)
+	set("reason", f.Reason)
+	set("ip", f.IP)
+	set("policy", f.Policy)
+	set("email", f.Email)
+	set("url", f.URL)
+	return v
+}
+
+// Errors matching the API's status codes, for use with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrUnavailable  = errors.New("service unavailable")
+	ErrServer       = errors.New("server error")
+	// The change feed cursor is too old: resync from Export.
+	ErrResyncRequired = errors.New("resync required")
+)
+
+// Error is returned when the API answers with an error status.
+type Error struct {
+	StatusCode int
+	Message    string // as sent by the API, e.g. "Network not found"
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("irc-glines-api: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusGone:
+		return ErrResyncRequired
+	case e.StatusCode == http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case e.StatusCode >= 500:
+		return ErrServer
+	default:
+		return ErrBadRequest
+	}
+}
+
+// Client calls the API. Its fields may be changed before first use.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// Number of times GET and DELETE requests are retried after a network
+	// error or a 502, 503 or 504 status. POST requests are never retried,
+	// as they aren't idempotent.
+	Retries int
+	// Wait before the first retry, doubled at each retry.
+	RetryWait time.Duration
+
+	dryRun *DryRunResult
+}
+
+// New returns a client of the API at baseURL, e.g. "http://127.0.0.1:2000",
+// authenticating with apiKey.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Retries:    2,
+		RetryWait:  500 * time.Millisecond,
+	}
+}
+
+// DryRun returns a copy of c whose state-changing calls are made in
+// dry-run mode: the API says what it would do, in result, and doesn't do
+// it. Their other results are then zero. Calls to endpoints that don't
+// support dry-run mode fail with a 501.
+func (c *Client) DryRun(result *DryRunResult) *Client {
+	d := *c
+	d.dryRun = result
+	return &d
+}
+
+// isDryRun reports whether a request is made in dry-run mode.
+func (c *Client) isDryRun(method string) bool {
+	return c.dryRun != nil && method != http.MethodGet && method != http.MethodHead
+}
+
+func retryable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// do sends a request and returns the response if its status is 200.
+// The caller must close the body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	return c.doAccept(ctx, method, path, query, body, http.StatusOK)
+}
+
+// doAccept is do, returning the response if its status is one of accept.
+func (c *Client) doAccept(ctx context.Context, method, path string, query url.Values, body any, accept ...int) (*http.Response, error) {
+	if c.isDryRun(method) {
+		q := url.Values{"dry_run": {"true"}}
+		for k, v := range query {
+			q[k] = v
+		}
+		query = q
+	}
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	tries := 1
+	if method == http.MethodGet || method == http.MethodDelete {
+		tries += c.Retries
+	}
+	wait := c.RetryWait
+	for try := 1; ; try++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.APIKey)
+		}
+		resp, err := c.HTTPClient.Do(req)
+		if err == nil && slices.Contains(accept, resp.StatusCode) {
+			return resp, nil
+		}
+		if err == nil {
+			apiErr := &Error{StatusCode: resp.StatusCode, Message: readErrorMessage(resp.Body)}
+			resp.Body.Close()
+			if !retryable(resp.StatusCode) {
+				return nil, apiErr
+			}
+			err = apiErr
+		}
+		if try >= tries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// readErrorMessage reads the message of an error response, which is a JSON
+// string.
+func readErrorMessage(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 4096))
+	var msg string
+	if json.Unmarshal(data, &msg) == nil {
+		return msg
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// call sends a request and decodes the JSON response into out.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if c.isDryRun(method) {
+		out = c.dryRun
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("irc-glines-api: invalid response: %w", err)
+	}
+	return nil
+}
+
+func apiPath(parts ...string) string {
+	for i, p := range parts {
+		if i > 0 {
+			parts[i] = url.PathEscape(p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// GlineLookup returns the glines matching an IP or a CIDR.
+func (c *Client) GlineLookup(ctx context.Context, network, ip string) ([]GlineData, error) {
+	var list []GlineData
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/glinelookup", network, ip), nil, nil, &list)
+	return list, err
+}
+
+// GlineLookupAt returns the glines that matched an IP or a CIDR at time
+// at, as recorded in the API's gline history.
+func (c *Client) GlineLookupAt(ctx context.Context, network, ip string, at time.Time) ([]GlineData, error) {
+	query := url.Values{"at": {strconv.FormatInt(at.Unix(), 10)}}
+	var list []GlineData
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/glinelookup", network, ip), query, nil, &list)
+	return list, err
+}
+
+// GlineIDLookup returns the gline with an ID, followed by the glines on
+// the same IP. Masks are redacted.
+func (c *Client) GlineIDLookup(ctx context.Context, network, id string) ([]GlineData, error) {
+	var list []GlineData
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/glineidlookup", network, id), nil, nil, &list)
+	return list, err
+}
+
+// IsMyIPGlined returns the glines matching the IP the request comes from.
+func (c *Client) IsMyIPGlined(ctx context.Context, network string) ([]GlineData, error) {
+	var list []GlineData
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/ismyipgline", network), nil, nil, &list)
+	return list, err
+}
+
+// SendCommand sends a raw IRC command.
+func (c *Client) SendCommand(ctx context.Context, network, command string) error {
+	body := map[string]string{"command": command}
+	return c.call(ctx, http.MethodPost, apiPath("/api2/sendcommand", network), nil, body, nil)
+}
+
+// RemoveGline removes a gline and posts message in the bot's main channel.
+// It returns once the gline is seen deactivated on IRC, which may take up
+// to the API's ConfirmTimeout: ctx and the HTTP client's timeout must allow
+// for it. The error is a 504 if the removal wasn't confirmed in time.
+//
+// If an approval rule holds the removal, it returns right away with the
+// pending removal, which happens once approved. Otherwise it returns nil.
+func (c *Client) RemoveGline(ctx context.Context, network, mask, message string) (*PendingRemoval, error) {
+	body := map[string]string{"glinemask": mask, "message": message}
+	resp, err := c.doAccept(ctx, http.MethodPost, apiPath("/api2/remgline", network), nil, body, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && c.isDryRun(http.MethodPost) {
+		if err := json.NewDecoder(resp.Body).Decode(c.dryRun); err != nil {
+			return nil, fmt.Errorf("irc-glines-api: invalid response: %w", err)
+		}
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return nil, err
+	}
+	var p PendingRemoval
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("irc-glines-api: invalid response: %w", err)
+	}
+	return &p, nil
+}
+
+// Approvals returns the removals waiting for approval, oldest first.
+func (c *Client) Approvals(ctx context.Context, network string) ([]PendingRemoval, error) {
+	var list []PendingRemoval
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/approvals", network), nil, nil, &list)
+	return list, err
+}
+
+// Approve approves a pending removal, which must have been requested with
+// another API key, and removes the gline. As with RemoveGline, it returns
+// once the removal is seen on IRC, and the error is a 504 if it wasn't in
+// time.
+func (c *Client) Approve(ctx context.Context, network string, id int) error {
+	return c.call(ctx, http.MethodPost, apiPath("/api2/approvals", network, strconv.Itoa(id)), nil, nil, nil)
+}
+
+// Status returns the state of the bot's connection to IRC.
+func (c *Client) Status(ctx context.Context, network string) (*Status, error) {
+	var st Status
+	if err := c.call(ctx, http.MethodGet, apiPath("/api2/status", network), nil, nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Health returns the health of every network. The API answers 503 when
+// any of them is unhealthy: the list is returned all the same, and no
+// error.
+func (c *Client) Health(ctx context.Context) ([]Health, error) {
+	resp, err := c.doAccept(ctx, http.MethodGet, "/api2/health", nil, nil, http.StatusOK, http.StatusServiceUnavailable)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list []Health
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("irc-glines-api: invalid response: %w", err)
+	}
+	return list, nil
+}
+
+// Metrics returns the metrics of every network, in the Prometheus text
+// format.
+func (c *Client) Metrics(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api2/metrics", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// Audit returns up to limit records of the API audit log matching f, the
+// most recent first. The API's default applies if limit is 0.
+func (c *Client) Audit(ctx context.Context, network string, f AuditFilter, limit int) ([]AuditRecord, error) {
+	query := url.Values{}
+	if f.Key != "" {
+		query.Set("key", f.Key)
+	}
+	if f.Mask != "" {
+		query.Set("mask", f.Mask)
+	}
+	if !f.Since.IsZero() {
+		query.Set("since", strconv.FormatInt(f.Since.Unix(), 10))
+	}
+	if !f.Until.IsZero() {
+		query.Set("until", strconv.FormatInt(f.Until.Unix(), 10))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var list []AuditRecord
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/audit", network), query, nil, &list)
+	return list, err
+}
+
+// Schedule schedules the removal or modification of a gline, and returns
+// the job.
+func (c *Client) Schedule(ctx context.Context, network string, r JobRequest) (*ScheduledJob, error) {
+	var job ScheduledJob
+	if err := c.call(ctx, http.MethodPost, apiPath("/api2/schedule", network), nil, r.body(), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ScheduledJobs returns the scheduled jobs, the next one first.
+func (c *Client) ScheduledJobs(ctx context.Context, network string) ([]ScheduledJob, error) {
+	var list []ScheduledJob
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/schedule", network), nil, nil, &list)
+	return list, err
+}
+
+// CancelJob cancels a scheduled job that didn't run yet.
+func (c *Client) CancelJob(ctx context.Context, network string, id int) error {
+	return c.call(ctx, http.MethodDelete, apiPath("/api2/schedule", network, strconv.Itoa(id)), nil, nil, nil)
+}
+
+// Watchlists returns the CIDRs of every watchlist, by name.
+func (c *Client) Watchlists(ctx context.Context, network string) (map[string][]string, error) {
+	var lists map[string][]string
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/watchlists", network), nil, nil, &lists)
+	return lists, err
+}
+
+// AddToWatchlist adds CIDRs to a watchlist, creating it if needed, and
+// returns its CIDRs.
+func (c *Client) AddToWatchlist(ctx context.Context, network, name string, cidrs ...string) ([]string, error) {
+	var list []string
+	body := map[string][]string{"cidrs": cidrs}
+	err := c.call(ctx, http.MethodPost, apiPath("/api2/watchlists", network, name), nil, body, &list)
+	return list, err
+}
+
+// RemoveFromWatchlist removes a CIDR from a watchlist, or the whole list if
+// cidr is "".
+func (c *Client) RemoveFromWatchlist(ctx context.Context, network, name, cidr string) error {
+	query := url.Values{}
+	if cidr != "" {
+		query.Set("cidr", cidr)
+	}
+	return c.call(ctx, http.MethodDelete, apiPath("/api2/watchlists", network, name), query, nil, nil)
+}
+
+// Search returns up to limit glines matching f. The API's default applies
+// if limit is 0.
+func (c *Client) Search(ctx context.Context, network string, f Filter, limit int) ([]GlineData, error) {
+	query := f.values()
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var list []GlineData
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/search", network), query, nil, &list)
+	return list, err
+}
+
+// Stats returns the top limit ASNs and countries by number of active
+// glines. The API's default applies if limit is 0.
+func (c *Client) Stats(ctx context.Context, network string, limit int) (*Stats, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var stats Stats
+	if err := c.call(ctx, http.MethodGet, apiPath("/api2/stats", network), query, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Export streams the glines matching f in format: jsonl, csv, cidr, cidr4,
+// cidr6 or rbldnsd. The caller must close the returned stream.
+func (c *Client) Export(ctx context.Context, network, format string, f Filter) (*ExportStream, error) {
+	query := f.values()
+	query.Set("format", format)
+	resp, err := c.do(ctx, http.MethodGet, apiPath("/api2/export", network), query, nil)
+	if err != nil {
+		return nil, err
+	}
+	cursor, _ := strconv.ParseUint(resp.Header.Get("X-Changes-Cursor"), 10, 64)
+	return &ExportStream{ReadCloser: resp.Body, Cursor: cursor}, nil
+}
+
+// ChangesCursor returns the current change feed cursor.
+func (c *Client) ChangesCursor(ctx context.Context, network string) (uint64, error) {
+	var changes Changes
+	err := c.call(ctx, http.MethodGet, apiPath("/api2/changes", network), nil, nil, &changes)
+	return changes.Cursor, err
+}
+
+// Changes returns up to limit changes following cursor since. The API's
+// default applies if limit is 0. The error wraps ErrResyncRequired when
+// changes following since were forgotten.
+func (c *Client) Changes(ctx context.Context, network string, since uint64, limit int) (*Changes, error) {
+	query := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var changes Changes
+	if err := c.call(ctx, http.MethodGet, apiPath("/api2/changes", network), query, nil, &changes); err != nil {
+		return nil, err
+	}
+	return &changes, nil
+}
+
+// Events calls fn for each event of the given types (all of them if none
+// is given) until ctx is done, the stream ends or fn returns an error.
+// The HTTP client's timeout applies to the whole stream, so use a client
+// without one.
+func (c *Client) Events(ctx context.Context, network string, fn func(Event) error, types ...string) error {
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("types", strings.Join(types, ","))
+	}
+	resp, err := c.do(ctx, http.MethodGet, apiPath("/api2/events", network), query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("irc-glines-api: invalid event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := New(srv.URL, "secret")
+	c.RetryWait = time.Millisecond
+	return c
+}
+
+func TestGlineLookup(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.EscapedPath(); got != "/api2/glinelookup/undernet/10.0.0.0%2F8" {
+			t.Errorf(`path = %s. Want /api2/glinelookup/undernet/10.0.0.0%%2F8`, got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf(`Authorization = %q. Want "Bearer secret"`, got)
+		}
+		json.NewEncoder(w).Encode([]GlineData{{Active: true, Mask: "*@10.1.2.3", Reason: "spam", ASN: 64500}})
+	})
+	list, err := c.GlineLookup(context.Background(), "undernet", "10.0.0.0/8")
+	if err != nil {
+		t.Fatalf(`GlineLookup() error: %s`, err.Error())
+	}
+	if len(list) != 1 || list[0].Mask != "*@10.1.2.3" || list[0].ASN != 64500 {
+		t.Errorf(`GlineLookup() = %+v. Want one gline on *@10.1.2.3 with ASN 64500`, list)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var tests = []struct {
+		status int
+		want   error
+	}{
+		{http.StatusBadRequest, ErrBadRequest},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusGone, ErrResyncRequired},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+		{http.StatusInternalServerError, ErrServer},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			json.NewEncoder(w).Encode("Network not found")
+		})
+		c.Retries = 0
+		_, err := c.Watchlists(context.Background(), "undernet")
+		if !errors.Is(err, tt.want) {
+			t.Errorf(`status %d: error = %v. Want %v`, tt.status, err, tt.want)
+		}
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.Message != "Network not found" {
+			t.Errorf(`status %d: error = %v. Want message "Network not found"`, tt.status, err)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode("Server not connected")
+			return
+		}
+		json.NewEncoder(w).Encode([]GlineData{})
+	})
+	if _, err := c.Search(context.Background(), "undernet", Filter{}, 0); err != nil {
+		t.Errorf(`Search() error: %s. Want success on the third try`, err.Error())
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf(`Search() made %d calls. Want 3`, got)
+	}
+
+	calls.Store(0)
+	_, err := c.RemoveGline(context.Background(), "undernet", "*@1.2.3.4", "removed")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf(`RemoveGline() error = %v. Want %v`, err, ErrUnavailable)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf(`RemoveGline() made %d calls. Want 1, POSTs aren't retried`, got)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		want := "active=true&limit=5&policy=p1"
+		if r.URL.RawQuery != want {
+			t.Errorf(`query = %s. Want %s`, r.URL.RawQuery, want)
+		}
+		json.NewEncoder(w).Encode([]GlineData{})
+	})
+	active := true
+	c.Search(context.Background(), "undernet", Filter{Active: &active, Policy: "p1"}, 5)
+}
+
+func TestEvents(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("types"); got != "gline.add,gline.modify" {
+			t.Errorf(`types = %s. Want gline.add,gline.modify`, got)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": connected\n\n")
+		fmt.Fprint(w, "event: gline.add\ndata: {\"type\":\"gline.add\",\"network\":\"undernet\",\"ts\":1,\"data\":{\"mask\":\"*@1.2.3.4\"}}\n\n")
+		fmt.Fprint(w, "event: gline.modify\ndata: {\"type\":\"gline.modify\",\"network\":\"undernet\",\"ts\":2,\"data\":{}}\n\n")
+	})
+	var got []string
+	err := c.Events(context.Background(), "undernet", func(ev Event) error {
+		got = append(got, ev.Type)
+		return nil
+	}, "gline.add", "gline.modify")
+	if err != nil {
+		t.Errorf(`Events() error: %s`, err.Error())
+	}
+	if len(got) != 2 || got[0] != "gline.add" || got[1] != "gline.modify" {
+		t.Errorf(`Events() got %v. Want [gline.add gline.modify]`, got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/health" {
+			t.Errorf(`path = %s. Want /api2/health`, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode([]Health{{Network: "undernet", State: "waiting"}})
+	})
+	list, err := c.Health(context.Background())
+	if err != nil {
+		t.Fatalf(`Health() error: %s. Want the list, even with a 503`, err.Error())
+	}
+	if len(list) != 1 || list[0].Healthy || list[0].State != "waiting" {
+		t.Errorf(`Health() = %+v. Want one unhealthy network`, list)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/metrics" {
+			t.Errorf(`path = %s. Want /api2/metrics`, r.URL.Path)
+		}
+		fmt.Fprint(w, "ircglines_connected{network=\"undernet\"} 1\n")
+	})
+	got, err := c.Metrics(context.Background())
+	if err != nil || got != "ircglines_connected{network=\"undernet\"} 1\n" {
+		t.Errorf(`Metrics() = %q, %v`, got, err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/audit/undernet" {
+			t.Errorf(`path = %s. Want /api2/audit/undernet`, r.URL.Path)
+		}
+		if want := "key=abuse&limit=10&since=1700000000"; r.URL.RawQuery != want {
+			t.Errorf(`query = %s. Want %s`, r.URL.RawQuery, want)
+		}
+		json.NewEncoder(w).Encode([]AuditRecord{{Source: "api", Command: "POST /api2/remgline/:network", Outcome: "ok", Key: "abuse"}})
+	})
+	list, err := c.Audit(context.Background(), "undernet", AuditFilter{Key: "abuse", Since: time.Unix(1700000000, 0)}, 10)
+	if err != nil || len(list) != 1 || list[0].Key != "abuse" {
+		t.Errorf(`Audit() = %+v, %v. Want one record`, list, err)
+	}
+}
+
+func TestRemoveGlineHeld(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api2/remgline/undernet":
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(PendingRemoval{ID: 3, Mask: "*@1.2.3.4", Rule: "auto", RequestedBy: "abuse"})
+		case "/api2/approvals/undernet/3":
+			if r.Method != http.MethodPost {
+				t.Errorf(`method = %s. Want POST`, r.Method)
+			}
+			json.NewEncoder(w).Encode("Removal confirmed")
+		case "/api2/approvals/undernet":
+			json.NewEncoder(w).Encode([]PendingRemoval{{ID: 3, Mask: "*@1.2.3.4"}})
+		default:
+			t.Errorf(`unexpected path %s`, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+	p, err := c.RemoveGline(ctx, "undernet", "*@1.2.3.4", "removed")
+	if err != nil || p == nil || p.ID != 3 || p.Rule != "auto" {
+		t.Fatalf(`RemoveGline() = %+v, %v. Want pending removal #3`, p, err)
+	}
+	if list, err := c.Approvals(ctx, "undernet"); err != nil || len(list) != 1 || list[0].ID != 3 {
+		t.Errorf(`Approvals() = %+v, %v. Want pending removal #3`, list, err)
+	}
+	if err := c.Approve(ctx, "undernet", 3); err != nil {
+		t.Errorf(`Approve(3) error: %s`, err.Error())
+	}
+}
+
+func TestRemoveGlineConfirmed(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode("Removal confirmed")
+	})
+	if p, err := c.RemoveGline(context.Background(), "undernet", "*@1.2.3.4", ""); p != nil || err != nil {
+		t.Errorf(`RemoveGline() = %+v, %v. Want nil, nil`, p, err)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api2/schedule/undernet":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["action"] != "modify" || body["mask"] != "*@1.2.3.4" || body["in"] != "6h0m0s" || body["duration"] != "2h0m0s" {
+				t.Errorf(`schedule body = %q`, body)
+			}
+			json.NewEncoder(w).Encode(ScheduledJob{ID: 1, Action: "modify", Mask: "*@1.2.3.4", Duration: 7200})
+		case r.Method == http.MethodGet && r.URL.Path == "/api2/schedule/undernet":
+			json.NewEncoder(w).Encode([]ScheduledJob{{ID: 1}})
+		case r.Method == http.MethodDelete && r.URL.Path == "/api2/schedule/undernet/1":
+			json.NewEncoder(w).Encode("Cancelled")
+		default:
+			t.Errorf(`unexpected %s %s`, r.Method, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+	job, err := c.Schedule(ctx, "undernet", JobRequest{Action: "modify", Mask: "*@1.2.3.4", In: 6 * time.Hour, Duration: 2 * time.Hour})
+	if err != nil || job.ID != 1 || job.Duration != 7200 {
+		t.Fatalf(`Schedule() = %+v, %v. Want job #1`, job, err)
+	}
+	if list, err := c.ScheduledJobs(ctx, "undernet"); err != nil || len(list) != 1 {
+		t.Errorf(`ScheduledJobs() = %+v, %v. Want job #1`, list, err)
+	}
+	if err := c.CancelJob(ctx, "undernet", 1); err != nil {
+		t.Errorf(`CancelJob(1) error: %s`, err.Error())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.URL.Query().Get("dry_run") != "true" {
+			t.Errorf(`%s %s without dry_run=true`, r.Method, r.URL)
+		}
+		switch {
+		case r.Method == http.MethodGet:
+			if r.URL.Query().Has("dry_run") {
+				t.Errorf(`GET %s with dry_run`, r.URL)
+			}
+			json.NewEncoder(w).Encode(map[string][]string{"abuse": {"10.0.0.0/8"}})
+		case r.URL.Path == "/api2/remgline/undernet":
+			json.NewEncoder(w).Encode(DryRunResult{DryRun: true, Lines: []string{"PRIVMSG X :remgline *@1.2.3.4"}})
+		case r.URL.Path == "/api2/watchlists/undernet/abuse":
+			if r.URL.Query().Get("cidr") != "10.0.0.0/8" {
+				t.Errorf(`DELETE %s without the cidr`, r.URL)
+			}
+			json.NewEncoder(w).Encode(DryRunResult{DryRun: true, Lines: []string{}})
+		default:
+			t.Errorf(`unexpected %s %s`, r.Method, r.URL.Path)
+		}
+	})
+	ctx := context.Background()
+	var res DryRunResult
+	dry := c.DryRun(&res)
+	if p, err := dry.RemoveGline(ctx, "undernet", "*@1.2.3.4", ""); err != nil || p != nil || !res.DryRun || len(res.Lines) != 1 {
+		t.Errorf(`RemoveGline() = %+v, %v, %+v. Want a dry-run result`, p, err, res)
+	}
+	res = DryRunResult{}
+	if err := dry.RemoveFromWatchlist(ctx, "undernet", "abuse", "10.0.0.0/8"); err != nil || !res.DryRun {
+		t.Errorf(`RemoveFromWatchlist() = %v, %+v. Want a dry-run result`, err, res)
+	}
+	if _, err := dry.Watchlists(ctx, "undernet"); err != nil {
+		t.Errorf(`Watchlists() error: %s`, err.Error())
+	}
+	if c.dryRun != nil {
+		t.Errorf(`DryRun() changed the original client`)
+	}
+}
@@ -6,6 +6,8 @@ require (
 	github.com/fluffle/goirc v1.3.1
 	github.com/hiddn/cidranger v1.0.3
 	github.com/labstack/echo/v4 v4.13.3
+	github.com/miekg/dns v1.1.73
+	github.com/oschwald/maxminddb-golang v1.13.1
 )
 
 require (
@@ -16,9 +18,9 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.52.0 // indirect
-	golang.org/x/net v0.55.0 // indirect
-	golang.org/x/sys v0.45.0 // indirect
-	golang.org/x/text v0.37.0 // indirect
+	golang.org/x/crypto v0.54.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
 	golang.org/x/time v0.8.0 // indirect
 )
@@ -12,8 +12,8 @@ func main() {
 	ircgline.Debug = config.Debug
 	s := ircgline.Irc_init(&config)
 	s.Connect()
+	go ircgline.Api_init(config)
 
-	// Wait for disconnect
-	ircgline.Api_init(config)
+	// Wait for !die
 	<-s.Quit
 }
@@ -1,9 +1,8 @@
 package abuse_glines
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hiddn/irc-glines-api/client"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -43,6 +43,7 @@ type ApiData struct {
 	ConfirmEmailMap          map[string]*confirmemail_struct
 	confirmEmailMapLastClean int64
 	TasksData                *TasksData
+	GlinesAPI                *client.Client
 }
 
 type confirmemailapi_struct struct {
@@ -146,8 +147,12 @@ func Api_init(conf Configuration) *echo.Echo {
 	a := &ApiData{
 		Config:       conf,
 		EchoInstance: e,
+		GlinesAPI:    client.New("http://127.0.0.1:2000", conf.ApiKey),
 		//Captcha:      captcha,
 	}
+	// Every call is bounded by its context: removals wait for their
+	// confirmation, longer than the client's default timeout.
+	a.GlinesAPI.HTTPClient.Timeout = 0
 	a.ConfirmEmailMap = make(map[string]*confirmemail_struct)
 	//config = conf
 
@@ -341,8 +346,10 @@ func (a *ApiData) requestRemGlineApi(c echo.Context) error {
 			}
 			if autoremove {
 				broadcast_message := fmt.Sprintf("Auto-removed G-line on %s | email: %s | nick: %s | name: %s | ip: %s | Message: %s", gline.Mask, in.Email, in.Nickname, in.RealName, remoteAddr, in.UserMessage)
-				if a.RemoveGline(in.Network, gline.Mask, broadcast_message) {
-					if retData.Message == "" {
+				if pending, ok := a.RemoveGline(in.Network, gline.Mask, broadcast_message); ok {
+					if pending != nil {
+						retData.Message = "Your G-line removal request was received and is awaiting approval by a staff member."
+					} else if retData.Message == "" {
 						retData.Message = "Your G-line was removed successfully."
 					}
 				} else {
@@ -594,131 +601,34 @@ func (a *ApiData) confirmEmailAPI(c echo.Context) error {
 	return c.HTML(http.StatusOK, "Your email is confirmed.<br><br>You can close this tab and go back to the abuse-glines web application.")
 }
 
-func (a *ApiData) RemoveGline(network, glineMask, message string) bool {
-	// Remove the gline
-	// Define the API endpoint template
+// RemoveGline removes a gline through the API. The pending removal is
+// returned if it awaits approval.
+func (a *ApiData) RemoveGline(network, glineMask, message string) (*client.PendingRemoval, bool) {
 	if a.Config.Testmode {
-		return true
-	}
-	baseURL := "http://127.0.0.1:2000/api2/remgline/%s"
-	url := fmt.Sprintf(baseURL, network)
-
-	// API Key (Bearer token)
-	apiKey := a.Config.ApiKey
-
-	// Create a new HTTP client with a timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second, // Set a timeout for the request
-	}
-
-	// Create the request body
-	requestBody, err := json.Marshal(map[string]string{
-		"glinemask": glineMask,
-		"network":   network,
-		"message":   message,
-	})
-	if err != nil {
-		log.Println("Error marshalling request body:", err)
-		return false
-	}
-
-	// Create a new HTTP POST request
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(requestBody)))
-	if err != nil {
-		log.Println("Failed to create HTTP request:", err)
-		return false
+		return nil, true
 	}
-
-	// Add headers
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+apiKey)
-
-	// Perform the HTTP request
-	resp, err := client.Do(req)
+	// The API waits up to its remover's ConfirmTimeout, 60s by default.
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+	pending, err := a.GlinesAPI.RemoveGline(ctx, network, glineMask, message)
 	if err != nil {
-		log.Println("Failed to make HTTP request:", err)
-		return false
-	}
-	defer resp.Body.Close()
-
-	// Check for a non-200 status code
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body) // Optionally read response body for debugging
-		log.Printf("API call failed with status %d: %s", resp.StatusCode, string(body))
-		return false
+		log.Println("Failed to remove gline:", err)
+		return nil, false
 	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Failed to read response body:", err)
-		return false
+	if pending != nil {
+		log.Printf("Removal of %s awaits approval (#%d, rule %s)\n", glineMask, pending.ID, pending.Rule)
 	}
-
-	debugLogf("%s\n", body)
-	return true
-}
-
-type RetGlineData struct {
-	Active           bool   `json:"active"`
-	Mask             string `json:"mask"`
-	ExpireTS         int64  `json:"expirets"`
-	LastModTS        int64  `json:"lastmodts"`
-	HoursUntilExpire int64  `json:"hoursuntilexpire"`
-	Reason           string `json:"reason"`
+	return pending, true
 }
 
-func (a *ApiData) lookupGlineAPI(ip, network string) ([]RetGlineData, error) {
-	// Define the API endpoint template
-	baseURL := "http://127.0.0.1:2000/api2/glinelookup/%s/%s"
-	url := fmt.Sprintf(baseURL, network, ip)
-	//fmt.Printf("checkgline lookup via URL: %s\n", url)
-
-	// API Key (Bearer token)
-	apiKey := a.Config.ApiKey // Replace with your actual API key
-
-	// Create a new HTTP client with a timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second, // Set a timeout for the request
-	}
-
-	// Create a new HTTP GET request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
-	}
-
-	// Add the Bearer token to the Authorization header
-	req.Header.Add("Authorization", "Bearer "+apiKey)
-
-	// Perform the HTTP request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Check for a non-200 status code
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body) // Optionally read response body for debugging
-		return nil, fmt.Errorf("API call failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
-
-	debugLogf("%s\n", body)
-	// Unmarshal JSON response into RetGlineData
-	var retGlines []RetGlineData
-	err = json.Unmarshal(body, &retGlines)
+func (a *ApiData) lookupGlineAPI(ip, network string) ([]client.GlineData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	retGlines, err := a.GlinesAPI.GlineLookup(ctx, network, ip)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response JSON: %w", err)
+		return nil, err
 	}
-
-	// Return the parsed data
+	debugLogf("%+v\n", retGlines)
 	return retGlines, nil
 }
 
@@ -0,0 +1,301 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// ACLConfig controls who may use which bot command.
+type ACLConfig struct {
+	// Per-command rules, keyed by command name without the leading '!'.
+	// Commands without a rule are open to anyone, unless they are
+	// privileged (e.g. die), in which case they are denied to everyone.
+	Commands map[string]ACLRule
+	// Append-only file receiving every privileged command invocation.
+	AuditLog string
+	// Ask the server for the IRCv3 account-tag capability, so services
+	// accounts are known without a WHOIS round-trip.
+	RequestAccountTag bool
+}
+
+// ACLRule grants a command. Channels restricts where it may be used (any
+// channel if empty). A user is then allowed if Anyone is set, or if they
+// match one of Hostmasks (nick!user@host globs), one of Accounts (services
+// account names), or are an IRC operator while Opers is set.
+type ACLRule struct {
+	Channels  []string
+	Hostmasks []string
+	Accounts  []string
+	Opers     bool
+	Anyone    bool
+}
+
+// whoisTTL is how long WHOIS results are trusted for ACL checks.
+const whoisTTL = 5 * 60
+
+// invocation describes who issued a bot command, and where.
+type invocation struct {
+	Nick    string
+	Ident   string
+	Host    string
+	Target  string // channel, or the bot's nick for private messages
+	Account string
+	Oper    bool
+	Text    string
+	Command string // Text without any "page <n>" suffix
+	Page    int
+}
+
+func newInvocation(line *irc.Line) *invocation {
+	inv := &invocation{
+		Nick:  line.Nick,
+		Ident: line.Ident,
+		Host:  line.Host,
+		Text:  line.Text(),
+	}
+	if len(line.Args) > 0 {
+		inv.Target = line.Args[0]
+	}
+	if acct, ok := line.Tags["account"]; ok && acct != "*" {
+		inv.Account = acct
+	}
+	return inv
+}
+
+func (inv *invocation) Hostmask() string {
+	return fmt.Sprintf("%s!%s@%s", inv.Nick, inv.Ident, inv.Host)
+}
+
+func (inv *invocation) IsChannel() bool {
+	return len(inv.Target) > 0 && (inv.Target[0] == '#' || inv.Target[0] == '&')
+}
+
+// ReplyTarget is where answers go: the channel, or the user for queries.
+func (inv *invocation) ReplyTarget() string {
+	if inv.IsChannel() {
+		return inv.Target
+	}
+	return inv.Nick
+}
+
+func (r ACLRule) channelAllowed(inv *invocation) bool {
+	if len(r.Channels) == 0 {
+		return true
+	}
+	for _, c := range r.Channels {
+		if strings.EqualFold(strings.Split(c, " ")[0], inv.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityAllowed reports whether inv's user is granted by r, with what is
+// currently known about them.
+func (r ACLRule) identityAllowed(inv *invocation) bool {
+	if r.Anyone {
+		return true
+	}
+	for _, m := range r.Hostmasks {
+		if MatchMask(m, inv.Hostmask()) {
+			return true
+		}
+	}
+	if inv.Account != "" {
+		for _, a := range r.Accounts {
+			if strings.EqualFold(a, inv.Account) {
+				return true
+			}
+		}
+	}
+	return r.Opers && inv.Oper
+}
+
+// needsWhois reports whether a WHOIS could change the outcome of
+// identityAllowed for inv.
+func (r ACLRule) needsWhois(inv *invocation) bool {
+	return r.Opers || (len(r.Accounts) > 0 && inv.Account == "")
+}
+
+func (s *serverData) aclRule(cmd string, privileged bool) ACLRule {
+	if rule, ok := s.Config.ACL.Commands[cmd]; ok {
+		return rule
+	}
+	return ACLRule{Anyone: !privileged}
+}
+
+// authorize checks cmd against the ACL and calls run if inv is allowed to
+// use it. Denied users get a notice. When the decision depends on the
+// user's account or oper status, it is deferred until a WHOIS completes.
+// Privileged commands are written to the audit log either way.
+func (s *serverData) authorize(cmd string, privileged bool, inv *invocation, run func()) {
+	rule := s.aclRule(cmd, privileged)
+	decide := func(allowed bool) {
+		outcome := "allowed"
+		if !allowed {
+			outcome = "denied"
+		}
+		if privileged {
+			s.Audit.Write(auditRecord{
+				Network: s.Config.Network,
+				Source:  "irc",
+				Actor:   inv.Hostmask(),
+				Account: inv.Account,
+				Target:  inv.Target,
+				Command: inv.Text,
+				Outcome: outcome,
+			})
+		}
+		if !allowed {
+			debugLogf("ACL: denied !%s to %s\n", cmd, inv.Hostmask())
+			s.Conn.Notice(inv.Nick, fmt.Sprintf("Permission denied: !%s", cmd))
+			return
+		}
+		run()
+	}
+	if !rule.channelAllowed(inv) {
+		decide(false)
+		return
+	}
+	if rule.identityAllowed(inv) || !rule.needsWhois(inv) {
+		decide(rule.identityAllowed(inv))
+		return
+	}
+	s.Whois.Lookup(s.Conn, inv.Nick, func(u *whoisInfo) {
+		if u != nil {
+			if inv.Account == "" {
+				inv.Account = u.Account
+			}
+			inv.Oper = u.Oper
+		}
+		decide(rule.identityAllowed(inv))
+	})
+}
+
+// whoisInfo is what the ACL needs to know about a user from WHOIS.
+type whoisInfo struct {
+	Account string
+	Oper    bool
+	TS      int64
+	seen    bool // RPL_WHOISUSER received, i.e. the nick exists
+}
+
+// whoisCache remembers recent WHOIS replies and the callbacks waiting for
+// replies still in flight.
+type whoisCache struct {
+	mu      sync.Mutex
+	users   map[string]*whoisInfo
+	pending map[string]*whoisInfo
+	waiters map[string][]func(*whoisInfo)
+}
+
+func newWhoisCache() *whoisCache {
+	return &whoisCache{
+		users:   make(map[string]*whoisInfo),
+		pending: make(map[string]*whoisInfo),
+		waiters: make(map[string][]func(*whoisInfo)),
+	}
+}
+
+// Lookup calls fn with nick's WHOIS information, sending a WHOIS if nothing
+// recent is cached. fn receives nil if the nick doesn't exist.
+func (w *whoisCache) Lookup(conn *irc.Conn, nick string, fn func(*whoisInfo)) {
+	key := strings.ToLower(nick)
+	now := time.Now().Unix()
+	w.mu.Lock()
+	if u, ok := w.users[key]; ok && now-u.TS < whoisTTL {
+		w.mu.Unlock()
+		fn(u)
+		return
+	}
+	w.waiters[key] = append(w.waiters[key], fn)
+	p, inFlight := w.pending[key]
+	if !inFlight || now-p.TS > 30 {
+		w.pending[key] = &whoisInfo{TS: now}
+		inFlight = false
+	}
+	w.mu.Unlock()
+	if !inFlight {
+		conn.Whois(nick)
+	}
+}
+
+// update applies fn to the in-flight WHOIS result for nick, if any.
+func (w *whoisCache) update(nick string, fn func(*whoisInfo)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p, ok := w.pending[strings.ToLower(nick)]; ok {
+		fn(p)
+	}
+}
+
+// finish completes the WHOIS for nick and runs the waiting callbacks,
+// passing nil if the server didn't know the nick.
+func (w *whoisCache) finish(nick string) {
+	key := strings.ToLower(nick)
+	w.mu.Lock()
+	p, ok := w.pending[key]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+	delete(w.pending, key)
+	waiters := w.waiters[key]
+	delete(w.waiters, key)
+	if p.seen {
+		w.users[key] = p
+	} else {
+		p = nil
+	}
+	w.mu.Unlock()
+	for _, fn := range waiters {
+		fn(p)
+	}
+}
+
+// Forget drops what is known about nick, e.g. when it quits or changes nick.
+func (w *whoisCache) Forget(nick string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.users, strings.ToLower(nick))
+}
+
+func handleWhoisUser(conn *irc.Conn, line *irc.Line) {
+	// :server 311 me nick user host * :real name
+	s := servers.GetServerInfos(conn)
+	if len(line.Args) < 2 {
+		return
+	}
+	s.Whois.update(line.Args[1], func(u *whoisInfo) { u.seen = true })
+}
+
+func handleWhoisOperator(conn *irc.Conn, line *irc.Line) {
+	// :server 313 me nick :is an IRC Operator
+	s := servers.GetServerInfos(conn)
+	if len(line.Args) < 2 {
+		return
+	}
+	s.Whois.update(line.Args[1], func(u *whoisInfo) { u.Oper = true })
+}
+
+func handleWhoisAccount(conn *irc.Conn, line *irc.Line) {
+	// :server 330 me nick account :is logged in as
+	s := servers.GetServerInfos(conn)
+	if len(line.Args) < 3 {
+		return
+	}
+	s.Whois.update(line.Args[1], func(u *whoisInfo) { u.Account = line.Args[2] })
+}
+
+func handleEndOfWhois(conn *irc.Conn, line *irc.Line) {
+	// :server 318 me nick :End of /WHOIS list.
+	s := servers.GetServerInfos(conn)
+	if len(line.Args) < 2 {
+		return
+	}
+	s.Whois.finish(line.Args[1])
+}
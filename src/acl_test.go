@@ -0,0 +1,83 @@
+package ircglineapi
+
+import "testing"
+
+func TestMatchMask(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*!*@staff.undernet.org", "Hidden!hid@staff.undernet.org", true},
+		{"*!*@*.undernet.org", "nick!user@STAFF.Undernet.org", true},
+		{"nick!?ser@host", "nick!user@host", true},
+		{"nick!user@host", "nick!user@host2", false},
+		{"*", "", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+	}
+	for _, c := range cases {
+		if res := MatchMask(c.pattern, c.s); res != c.want {
+			t.Errorf(`MatchMask(%q, %q) = %t. Want %t`, c.pattern, c.s, res, c.want)
+		}
+	}
+}
+
+func TestACLRuleIdentityAllowed(t *testing.T) {
+	inv := &invocation{Nick: "hid", Ident: "user", Host: "staff.undernet.org", Target: "#opers"}
+	cases := []struct {
+		name string
+		rule ACLRule
+		acct string
+		oper bool
+		want bool
+	}{
+		{"anyone", ACLRule{Anyone: true}, "", false, true},
+		{"empty rule", ACLRule{}, "", false, false},
+		{"hostmask", ACLRule{Hostmasks: []string{"*!*@staff.undernet.org"}}, "", false, true},
+		{"account", ACLRule{Accounts: []string{"Hidden"}}, "hidden", false, true},
+		{"wrong account", ACLRule{Accounts: []string{"Hidden"}}, "other", false, false},
+		{"oper", ACLRule{Opers: true}, "", true, true},
+		{"not oper", ACLRule{Opers: true}, "", false, false},
+	}
+	for _, c := range cases {
+		inv.Account, inv.Oper = c.acct, c.oper
+		if res := c.rule.identityAllowed(inv); res != c.want {
+			t.Errorf(`%s: identityAllowed() = %t. Want %t`, c.name, res, c.want)
+		}
+	}
+}
+
+func TestACLRuleChannelAllowed(t *testing.T) {
+	rule := ACLRule{Channels: []string{"#opers key"}}
+	if !rule.channelAllowed(&invocation{Target: "#OPERS"}) {
+		t.Errorf(`channelAllowed(#OPERS) = false. Want true`)
+	}
+	if rule.channelAllowed(&invocation{Target: "#public"}) {
+		t.Errorf(`channelAllowed(#public) = true. Want false`)
+	}
+}
+
+func TestWhoisCacheFinish(t *testing.T) {
+	w := newWhoisCache()
+	w.pending["hid"] = &whoisInfo{}
+	var got *whoisInfo
+	called := false
+	w.waiters["hid"] = []func(*whoisInfo){func(u *whoisInfo) { got, called = u, true }}
+	w.update("Hid", func(u *whoisInfo) { u.seen = true })
+	w.update("Hid", func(u *whoisInfo) { u.Account = "hidden" })
+	w.update("Hid", func(u *whoisInfo) { u.Oper = true })
+	w.finish("HID")
+	if !called || got == nil || got.Account != "hidden" || !got.Oper {
+		t.Fatalf("finish() passed %+v to waiter. Want account hidden, oper", got)
+	}
+	if _, ok := w.users["hid"]; !ok {
+		t.Errorf("WHOIS result was not cached")
+	}
+
+	w.pending["ghost"] = &whoisInfo{}
+	w.waiters["ghost"] = []func(*whoisInfo){func(u *whoisInfo) { got = u }}
+	w.finish("ghost")
+	if got != nil {
+		t.Errorf("finish() for an unknown nick passed %+v. Want nil", got)
+	}
+}
@@ -0,0 +1,161 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Classes of announcements, as used in AnnounceConfig.Routes.
+const (
+	announceParseError = "parseerror" // unparsable gline notices
+	announceRemoval    = "removal"    // removals, their approval and scheduling
+	announceWatchlist  = "watchlist"  // glines overlapping a watchlist
+	announceSafeguard  = "safeguard"  // safeguard violations
+	announceConnection = "connection" // reconnections, OperServ login failures
+	announceExpiry     = "expiry"     // glines reaching their expiration time
+)
+
+var announceClasses = []string{announceParseError, announceRemoval, announceWatchlist, announceSafeguard, announceConnection, announceExpiry}
+
+// AnnounceConfig routes the bot's announcements by class. Classes without
+// a route go to the main channel, the first of Channels.
+type AnnounceConfig struct {
+	Routes map[string]AnnounceRoute
+}
+
+// AnnounceRoute says where announcements of a class go, and how.
+type AnnounceRoute struct {
+	// Channels or nicks. The main channel if empty.
+	Targets []string
+	// Drop the announcements of this class.
+	Mute bool
+	// Text sent, which may reference $message, $class and $network.
+	// Defaults to "$message".
+	Template string
+	// Announcements beyond this many per minute are dropped, and counted
+	// in the next one sent. 0 means no limit.
+	MaxPerMinute int
+}
+
+func checkAnnounceRoutes(cfg *AnnounceConfig) {
+	for class := range cfg.Routes {
+		if !containsFold(announceClasses, class) {
+			log.Fatalf("announce: unknown class %q. Known classes: %s\n", class, strings.Join(announceClasses, ", "))
+		}
+	}
+}
+
+// announceLimiter is a token bucket holding up to MaxPerMinute tokens.
+type announceLimiter struct {
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+type announcer struct {
+	mu       sync.Mutex
+	limiters map[string]*announceLimiter
+}
+
+func newAnnouncer() *announcer {
+	return &announcer{limiters: make(map[string]*announceLimiter)}
+}
+
+// allow takes a token for class, and returns whether the announcement may
+// be sent and how many were suppressed before it.
+func (a *announcer) allow(class string, perMinute int, now time.Time) (bool, int) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := a.limiters[class]
+	if l == nil {
+		l = &announceLimiter{tokens: float64(perMinute), last: now}
+		a.limiters[class] = l
+	}
+	l.tokens += now.Sub(l.last).Minutes() * float64(perMinute)
+	if l.tokens > float64(perMinute) {
+		l.tokens = float64(perMinute)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// route returns the route of class. Watchlist alerts go to
+// Watchlists.AlertChannel if they have no route of their own.
+func (s *serverData) route(class string) AnnounceRoute {
+	for name, r := range s.Config.Announce.Routes {
+		if strings.EqualFold(name, class) {
+			return r
+		}
+	}
+	if class == announceWatchlist && s.Config.Watchlists.AlertChannel != "" {
+		return AnnounceRoute{Targets: []string{s.Config.Watchlists.AlertChannel}}
+	}
+	return AnnounceRoute{}
+}
+
+// announcement returns the targets and text of msg, without rate limiting.
+func (s *serverData) announcement(class, msg string) ([]string, string) {
+	r := s.route(class)
+	if r.Mute {
+		return nil, ""
+	}
+	targets := r.Targets
+	if len(targets) == 0 {
+		if ch := s.mainChannel(); ch != "" {
+			targets = []string{ch}
+		}
+	}
+	text := msg
+	if r.Template != "" {
+		text = strings.NewReplacer("$message", msg, "$class", class, "$network", s.Config.Network).Replace(r.Template)
+	}
+	return targets, text
+}
+
+// announceLines returns the IRC lines announce would send for msg.
+func (s *serverData) announceLines(class, msg string) []string {
+	if msg == "" {
+		return nil
+	}
+	targets, text := s.announcement(class, msg)
+	lines := make([]string, 0, len(targets))
+	for _, t := range targets {
+		lines = append(lines, fmt.Sprintf("PRIVMSG %s :%s", t, text))
+	}
+	return lines
+}
+
+// announce sends msg where the route of class says, and returns the lines
+// queued.
+func (s *serverData) announce(class, msg string) []string {
+	if msg == "" || !s.Conn.Connected() {
+		return nil
+	}
+	r := s.route(class)
+	ok, suppressed := s.Announcer.allow(class, r.MaxPerMinute, time.Now())
+	if !ok {
+		debugLogf("serverData.announce(): %s announcement suppressed: %s\n", class, msg)
+		return nil
+	}
+	if suppressed > 0 {
+		msg += fmt.Sprintf(" (%d more suppressed)", suppressed)
+	}
+	targets, text := s.announcement(class, msg)
+	for _, t := range targets {
+		s.Out.Enqueue(t, text)
+	}
+	return s.announceLines(class, msg)
+}
@@ -0,0 +1,56 @@
+package ircglineapi
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnounceLines(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "announcenet", Server: "hidden.undernet.org", Nick: "GLA1",
+		Channels:   []string{"#ops key"},
+		Watchlists: WatchlistConfig{AlertChannel: "#alerts"},
+		Announce: AnnounceConfig{Routes: map[string]AnnounceRoute{
+			"ParseError": {Targets: []string{"#debug", "hid"}, Template: "[$network/$class] $message"},
+			"expiry":     {Mute: true},
+		}}})
+	var tests = []struct {
+		class string
+		want  []string
+	}{
+		{announceRemoval, []string{"PRIVMSG #ops :hello"}},
+		{announceParseError, []string{"PRIVMSG #debug :[announcenet/parseerror] hello", "PRIVMSG hid :[announcenet/parseerror] hello"}},
+		{announceExpiry, []string{}},
+		{announceWatchlist, []string{"PRIVMSG #alerts :hello"}},
+	}
+	for _, tt := range tests {
+		if got := s.announceLines(tt.class, "hello"); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf(`announceLines(%q) = %q. Want %q`, tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestAnnouncerAllow(t *testing.T) {
+	a := newAnnouncer()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := a.allow("removal", 2, now); !ok {
+			t.Errorf(`allow() #%d = false. Want true`, i+1)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ok, _ := a.allow("removal", 2, now); ok {
+			t.Errorf(`allow() over the limit = true. Want false`)
+		}
+	}
+	if ok, _ := a.allow("expiry", 2, now); !ok {
+		t.Errorf(`allow() of another class = false. Want true`)
+	}
+	// A token comes back every 30 seconds.
+	if ok, suppressed := a.allow("removal", 2, now.Add(30*time.Second)); !ok || suppressed != 3 {
+		t.Errorf(`allow() after 30s = %v, %d. Want true, 3 suppressed`, ok, suppressed)
+	}
+	if ok, _ := a.allow("removal", 0, now); !ok {
+		t.Errorf(`allow() without a limit = false. Want true`)
+	}
+}
@@ -1,9 +1,13 @@
 package ircglineapi
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
+	"github.com/hiddn/irc-glines-api/client"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -11,22 +15,18 @@ import (
 type ApiData struct {
 	Config       Configuration
 	EchoInstance *echo.Echo
+	Audit        *auditLog // API calls; nil if ApiAuditLog isn't set
 }
 
-type RetGlineData struct {
-	Active           bool   `json:"active"`
-	Mask             string `json:"mask"`
-	ExpireTS         int64  `json:"expirets"`
-	LastModTS        int64  `json:"lastmodts"`
-	HoursUntilExpire int64  `json:"hoursuntilexpire"`
-	Reason           string `json:"reason"`
-	ID               string `json:"id"`
-}
+// RetGlineData is defined by the client package so that the API and its
+// clients can't drift apart.
+type RetGlineData = client.GlineData
+
 type RetGlineDatas struct {
 	RetGlineData []RetGlineData `json:"glines"`
 }
 
-func newRetGlineData(mask, reason string, expireTS, lastModTS, hoursUntilExpire int64, active bool, id string) *RetGlineData {
+func newRetGlineData(mask, reason string, expireTS, lastModTS, hoursUntilExpire int64, active bool, id, setter string) *RetGlineData {
 	return &RetGlineData{
 		Active:           active,
 		Mask:             mask,
@@ -35,9 +35,20 @@ func newRetGlineData(mask, reason string, expireTS, lastModTS, hoursUntilExpire
 		HoursUntilExpire: hoursUntilExpire,
 		Reason:           reason,
 		ID:               id,
+		Setter:           setter,
 	}
 }
 
+// setReasonInfo fills in the fields parsed from the reason.
+func setReasonInfo(r *RetGlineData, info reasonInfo) {
+	r.Auto = info.Auto
+	r.Level = info.Level
+	r.IP = info.IP
+	r.Policy = info.Policy
+	r.Email = info.Email
+	r.URL = info.URL
+}
+
 // redactMaskHost replaces the host part of a "user@host" mask with a
 // placeholder, keeping the user part intact.
 func redactMaskHost(mask string) string {
@@ -49,7 +60,8 @@ func redactMaskHost(mask string) string {
 
 // buildRetGlineDataList builds the JSON response list for a set of gline
 // entries. When redactIP is true (public ID-based lookups), the mask's
-// host/IP part is replaced with a placeholder.
+// host/IP part is replaced with a placeholder and the IP parsed from the
+// reason is left out.
 func buildRetGlineDataList(entries []*glineData, redactIP bool) []*RetGlineData {
 	list := make([]*RetGlineData, 0, len(entries))
 	for _, e := range entries {
@@ -57,7 +69,26 @@ func buildRetGlineDataList(entries []*glineData, redactIP bool) []*RetGlineData
 		if redactIP {
 			mask = redactMaskHost(mask)
 		}
-		list = append(list, newRetGlineData(mask, e.reason, e.expireTS, e.lastModTS, e.HoursUntilExpiration(), e.active, e.ID()))
+		r := newRetGlineData(mask, e.reason, e.expireTS, e.lastModTS, e.HoursUntilExpiration(), e.active, e.ID(), e.Setter())
+		setReasonInfo(r, e.Info())
+		if redactIP {
+			r.IP = ""
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// lookupRetGlineDataList is buildRetGlineDataList plus what the lookup
+// endpoints add to each gline: its explanation in the best of langs and,
+// unless redactIP is true, its ASN and country.
+func (s *serverData) lookupRetGlineDataList(entries []*glineData, redactIP bool, langs []string) []*RetGlineData {
+	list := buildRetGlineDataList(entries, redactIP)
+	for i, g := range entries {
+		list[i].Explanation = s.Explanations.Explain(g, langs)
+		if !redactIP {
+			setGeoInfo(list[i], s.geoInfoOf(g))
+		}
 	}
 	return list
 }
@@ -65,6 +96,7 @@ func buildRetGlineDataList(entries []*glineData, redactIP bool) []*RetGlineData
 type api_struct struct {
 	Network string `param:"network"`
 	Ip      string `param:"ip"`
+	At      string `query:"at"`
 }
 
 type api_struct_id struct {
@@ -90,11 +122,26 @@ type api_remgline_struct struct {
 }
 
 func Api_init(config Configuration) *echo.Echo {
+	config.ApiAuditLog = config.apiAuditLog()
+	e := newApi(config)
+	e.Logger.Fatal(e.Start("127.0.0.1:2000"))
+	return e
+}
+
+// newApi sets up the routes and middlewares of the API.
+func newApi(config Configuration) *echo.Echo {
 	e := echo.New()
 	a := &ApiData{
 		Config:       config,
 		EchoInstance: e,
 	}
+	if config.ApiAuditLog != "" {
+		audit, err := openAuditLog(config.ApiAuditLog)
+		if err != nil {
+			log.Fatal("Can't open API audit log:", err)
+		}
+		a.Audit = audit
+	}
 	e.Use(middleware.BodyLimit("1K"))
 	e.Use(middleware.Logger())
 	e.GET("/api2/glinelookup/:network/:ip", a.glineLookupApi)
@@ -102,14 +149,50 @@ func Api_init(config Configuration) *echo.Echo {
 	e.GET("/api2/ismyipgline/:network", a.glineLookupOwnIPApi)
 	e.POST("/api2/sendcommand/:network", a.sendCommandApi)
 	e.POST("/api2/remgline/:network", a.removeGlineApi)
+	e.GET("/api2/watchlists/:network", a.getWatchlistsApi)
+	e.POST("/api2/watchlists/:network/:name", a.addWatchlistApi)
+	e.DELETE("/api2/watchlists/:network/:name", a.removeWatchlistApi)
+	e.GET("/api2/events/:network", a.eventsApi)
+	e.GET("/api2/export/:network", a.exportApi)
+	e.GET("/api2/search/:network", a.searchApi)
+	e.GET("/api2/stats/:network", a.statsApi)
+	e.GET("/api2/changes/:network", a.changesApi)
+	e.GET("/api2/status/:network", a.statusApi)
+	e.GET("/api2/health", a.healthApi)
+	e.GET("/api2/metrics", a.metricsApi)
+	e.GET("/api2/audit/:network", a.auditApi)
+	e.GET("/api2/approvals/:network", a.getApprovalsApi)
+	e.POST("/api2/approvals/:network/:id", a.approveApi)
+	e.GET("/api2/schedule/:network", a.getScheduleApi)
+	e.POST("/api2/schedule/:network", a.scheduleApi)
+	e.DELETE("/api2/schedule/:network/:id", a.cancelScheduleApi)
+	a.registerApi3(e)
 	e.Use(middleware.Recover())
 	e.Use(middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
 		Skipper: a.IsAPIOpen,
 		Validator: func(key string, c echo.Context) (bool, error) {
-			return key == config.ApiKey, nil
+			name := config.apiKeyName(key)
+			c.Set(apiKeyNameKey, name)
+			return name != "", nil
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			if strings.HasPrefix(c.Path(), "/api3/") {
+				a.auditUnauthorized(c, http.StatusUnauthorized)
+				return newApi3Error(http.StatusUnauthorized, api3ErrUnauthorized, "missing or invalid API key")
+			}
+			// As echo does without an ErrorHandler: 400 if the key is
+			// missing, 401 if it's invalid.
+			var missing *middleware.ErrKeyAuthMissing
+			if errors.As(err, &missing) {
+				a.auditUnauthorized(c, http.StatusBadRequest)
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			a.auditUnauthorized(c, http.StatusUnauthorized)
+			return &echo.HTTPError{Code: http.StatusUnauthorized, Message: "Unauthorized", Internal: err}
 		},
 	}))
-	e.Logger.Fatal(e.Start("127.0.0.1:2000"))
+	e.Use(a.auditApiCalls)
+	e.Use(a.dryRunFlag)
 	return e
 }
 
@@ -121,8 +204,10 @@ func (a *ApiData) IsAPIOpen(c echo.Context) bool {
 		return true
 	case "/api2/ismyipgline/:network":
 		return true
+	case "/api2/health":
+		return true
 	default:
-		return false
+		return isApi3Open(c.Request().Method, c.Path())
 	}
 }
 
@@ -136,16 +221,31 @@ func (a *ApiData) removeGlineApi(c echo.Context) error {
 	if s == nil {
 		return c.JSON(http.StatusNotFound, "Network not found")
 	}
-	if !s.Conn.Connected() {
-		return c.JSON(http.StatusServiceUnavailable, "Server not connected")
+	c.Set(auditTargetKey, in.GlineMask)
+	if !validMask(in.GlineMask) {
+		return c.JSON(http.StatusBadRequest, "Invalid mask")
 	}
-	s.sendCommandToOperServ(strings.Replace(s.Config.OperServRemglineCmd, "$glinemask", in.GlineMask, -1))
 	if len(in.Message) > 400 {
 		in.Message = in.Message[:400] + " [...]"
 	}
 	in.Message = strings.ReplaceAll(in.Message, "\n", "|")
-	s.MsgMainChan(in.Message)
-	return c.JSON(http.StatusOK, "Command sent")
+	if isDryRun(c) {
+		return c.JSON(http.StatusOK, s.dryRunRemoval(in.GlineMask, "", in.Message))
+	}
+	if !s.Conn.Connected() {
+		return c.JSON(http.StatusServiceUnavailable, "Server not connected")
+	}
+	key, _ := c.Get(apiKeyNameKey).(string)
+	if pending := s.holdRemoval(in.GlineMask, "", key); pending != nil {
+		s.auditedAnnounce(c, announceRemoval, in.Message)
+		return c.JSON(http.StatusAccepted, pending)
+	}
+	auditLines(c, s.Remover.Lines(in.GlineMask, "")...)
+	s.auditedAnnounce(c, announceRemoval, in.Message)
+	if err := s.confirmedRemoval(in.GlineMask, ""); err != nil {
+		return c.JSON(removalStatus(err), fmt.Sprintf("Removal via %s failed: %s", s.Remover, err.Error()))
+	}
+	return c.JSON(http.StatusOK, "Removal confirmed")
 }
 
 func (a *ApiData) sendCommandApi(c echo.Context) error {
@@ -158,10 +258,14 @@ func (a *ApiData) sendCommandApi(c echo.Context) error {
 	if s == nil {
 		return c.JSON(http.StatusNotFound, "Network not found")
 	}
+	if isDryRun(c) {
+		return c.JSON(http.StatusOK, s.dryRunCommand(in.Command))
+	}
 	if !s.Conn.Connected() {
 		return c.JSON(http.StatusServiceUnavailable, "Server not connected")
 	}
 	s.Conn.Raw(in.Command)
+	auditLines(c, in.Command)
 	return c.JSON(http.StatusOK, "Command sent")
 }
 
@@ -182,7 +286,7 @@ func (a *ApiData) glineIDLookupApi(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, "Network not found")
 	}
 	entries, _ := s.CheckGlineByID(in.ID)
-	list := buildRetGlineDataList(entries, true)
+	list := s.lookupRetGlineDataList(entries, true, parseAcceptLanguage(c.Request().Header.Get("Accept-Language")))
 	return c.JSON(http.StatusOK, &list)
 }
 
@@ -208,8 +312,11 @@ func (a *ApiData) glineApi(c echo.Context, in api_struct, err error) error {
 	if s == nil {
 		return c.JSON(http.StatusNotFound, "Network not found")
 	}
+	if in.At != "" {
+		return a.glineAtApi(c, s, in)
+	}
 	if glines, exp_glines, err := s.CheckGline(in.Ip, false); err == nil {
-		list = buildRetGlineDataList(append(glines, exp_glines...), false)
+		list = s.lookupRetGlineDataList(append(glines, exp_glines...), false, parseAcceptLanguage(c.Request().Header.Get("Accept-Language")))
 	} else {
 		return c.JSON(http.StatusBadRequest, "Invalid IP")
 	}
@@ -0,0 +1,494 @@
+package ircglineapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// /api3 serves the same data as /api2, with every response wrapped in an
+// api3Response and errors identified by a machine-readable code.
+
+const (
+	api3ErrBadRequest        = "bad_request"
+	api3ErrInvalidParam      = "invalid_parameter"
+	api3ErrInvalidIP         = "invalid_ip"
+	api3ErrInvalidID         = "invalid_id"
+	api3ErrInvalidMask       = "invalid_mask"
+	api3ErrNetworkNotFound   = "network_not_found"
+	api3ErrNotConnected      = "server_not_connected"
+	api3ErrUnauthorized      = "unauthorized"
+	api3ErrNotFound          = "not_found"
+	api3ErrInternal          = "internal_error"
+	api3ErrRemovalFailed     = "removal_failed"
+	api3ErrDryRunUnsupported = "dry_run_unsupported"
+)
+
+type api3Response struct {
+	Data  any        `json:"data"`
+	Error *api3Error `json:"error"`
+	Meta  *api3Meta  `json:"meta,omitempty"`
+}
+
+type api3Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *api3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type api3Meta struct {
+	Network string `json:"network,omitempty"`
+	Count   *int   `json:"count,omitempty"`
+	TS      int64  `json:"ts"`
+}
+
+func newApi3Error(status int, code, format string, args ...any) *api3Error {
+	return &api3Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Send writes e as the response.
+func (e *api3Error) Send(c echo.Context) error {
+	return c.JSON(e.Status, &api3Response{Error: e, Meta: &api3Meta{TS: time.Now().Unix()}})
+}
+
+// api3OK writes data as the response. Lists get their length in the meta.
+func api3OK(c echo.Context, network string, data any) error {
+	return api3Respond(c, http.StatusOK, network, data)
+}
+
+// api3Accepted answers 202: the request is held, and data says how to
+// follow it.
+func api3Accepted(c echo.Context, network string, data any) error {
+	return api3Respond(c, http.StatusAccepted, network, data)
+}
+
+func api3Respond(c echo.Context, status int, network string, data any) error {
+	meta := &api3Meta{Network: network, TS: time.Now().Unix()}
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+		n := v.Len()
+		meta.Count = &n
+	}
+	return c.JSON(status, &api3Response{Data: data, Meta: meta})
+}
+
+// api3Input is implemented by the input structs, to validate them once
+// bound.
+type api3Input interface {
+	Validate() *api3Error
+}
+
+func api3Bind(c echo.Context, in api3Input) *api3Error {
+	if err := c.Bind(in); err != nil {
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			return newApi3Error(http.StatusBadRequest, api3ErrBadRequest, "%v", he.Message)
+		}
+		return newApi3Error(http.StatusBadRequest, api3ErrBadRequest, "%s", err.Error())
+	}
+	return in.Validate()
+}
+
+// api3Server returns the server of network.
+func api3Server(network string) (*serverData, *api3Error) {
+	s := servers.GetServerInfosByNetwork(network)
+	if s == nil {
+		return nil, newApi3Error(http.StatusNotFound, api3ErrNetworkNotFound, "network %s not found", network)
+	}
+	return s, nil
+}
+
+func (s *serverData) api3Connected() *api3Error {
+	if !s.Conn.Connected() {
+		return newApi3Error(http.StatusServiceUnavailable, api3ErrNotConnected, "not connected to %s", s.Config.Network)
+	}
+	return nil
+}
+
+// api3Param documents a path or query parameter.
+type api3Param struct {
+	Name        string
+	In          string // path or query
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// api3Route is an entry of the /api3 route registry, from which the routes
+// are registered and the OpenAPI document is generated.
+type api3Route struct {
+	Method   string
+	Path     string // echo syntax, e.g. /api3/glinelookup/:network/:ip
+	Summary  string
+	Open     bool // no API key needed
+	Params   []api3Param
+	Body     any // sample of the JSON body, nil if none
+	Data     any // sample of the response data
+	Accepted any // sample of the data of a 202 response, nil if none
+	Handler  func(a *ApiData, c echo.Context) error
+}
+
+var networkParam = api3Param{"network", "path", "string", "Network name"}
+
+var filterParams = []api3Param{
+	{"active", "query", "boolean", "Active or inactive glines only"},
+	{"setter", "query", "string", "Server that set the gline (wildcards allowed)"},
+	{"reason", "query", "string", "Regex the reason must match (case-insensitive)"},
+	{"auto", "query", "boolean", "Automatic glines only, or manual ones only"},
+	{"level", "query", "integer", "Level parsed from the reason"},
+	{"ip", "query", "string", "IP or CIDR containing the IP parsed from the reason"},
+	{"policy", "query", "string", "Policy code parsed from the reason"},
+	{"email", "query", "string", "Contact email parsed from the reason (wildcards allowed)"},
+	{"url", "query", "string", "Info URL parsed from the reason (wildcards allowed)"},
+	{"limit", "query", "integer", "Maximum number of glines returned, 1-1000 (default 100)"},
+}
+
+var api3Routes = []*api3Route{
+	{
+		Method:  http.MethodGet,
+		Path:    "/api3/glinelookup/:network/:ip",
+		Summary: "Glines matching an IP or CIDR",
+		Open:    true,
+		Params:  []api3Param{networkParam, {"ip", "path", "string", "IP or CIDR"}},
+		Data:    []*RetGlineData{},
+		Handler: (*ApiData).api3GlineLookup,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api3/glineidlookup/:network/:id",
+		Summary: "Gline with an ID, then the glines on the same IP. Masks are redacted",
+		Open:    true,
+		Params:  []api3Param{networkParam, {"id", "path", "string", "Gline ID, e.g. D1785006545-2001"}},
+		Data:    []*RetGlineData{},
+		Handler: (*ApiData).api3GlineIDLookup,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api3/ismyipgline/:network",
+		Summary: "Glines matching the caller's IP",
+		Open:    true,
+		Params:  []api3Param{networkParam},
+		Data:    []*RetGlineData{},
+		Handler: (*ApiData).api3GlineLookupOwnIP,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api3/search/:network",
+		Summary: "Glines matching filters",
+		Params:  append([]api3Param{networkParam}, filterParams...),
+		Data:    []*RetGlineData{},
+		Handler: (*ApiData).api3Search,
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api3/stats/:network",
+		Summary: "Active glines per ASN and per country",
+		Params:  []api3Param{networkParam, {"limit", "query", "integer", "Number of ASNs and countries returned (default 20)"}},
+		Data:    &geoStats{},
+		Handler: (*ApiData).api3Stats,
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api3/remgline/:network",
+		Summary:  "Remove a gline and wait for its deactivation to be seen on IRC, posting a message in the main channel. A removal held for approval is answered with 202 and the pending removal",
+		Params:   []api3Param{networkParam},
+		Body:     &api3RemglineInput{},
+		Data:     "",
+		Accepted: &pendingRemoval{},
+		Handler:  (*ApiData).api3RemoveGline,
+	},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api3/sendcommand/:network",
+		Summary: "Send a raw IRC command",
+		Params:  []api3Param{networkParam},
+		Body:    &api3CommandInput{},
+		Data:    "",
+		Handler: (*ApiData).api3SendCommand,
+	},
+}
+
+const api3OpenAPIPath = "/api3/openapi.json"
+
+// registerApi3 adds the /api3 routes to e.
+func (a *ApiData) registerApi3(e *echo.Echo) {
+	for _, r := range api3Routes {
+		handler := r.Handler
+		e.Add(r.Method, r.Path, func(c echo.Context) error { return handler(a, c) })
+	}
+	e.GET(api3OpenAPIPath, a.api3OpenAPI)
+	defaultHandler := e.HTTPErrorHandler
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		if !strings.HasPrefix(c.Request().URL.Path, "/api3/") || c.Response().Committed {
+			defaultHandler(err, c)
+			return
+		}
+		api3HTTPError(err).Send(c)
+	}
+}
+
+// api3HTTPError converts the errors echo and its middlewares return.
+func api3HTTPError(err error) *api3Error {
+	var ae *api3Error
+	if errors.As(err, &ae) {
+		return ae
+	}
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		return newApi3Error(http.StatusInternalServerError, api3ErrInternal, "internal error")
+	}
+	code := api3ErrBadRequest
+	switch he.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = api3ErrUnauthorized
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		code = api3ErrNotFound
+	case http.StatusInternalServerError:
+		code = api3ErrInternal
+	}
+	return newApi3Error(he.Code, code, "%v", he.Message)
+}
+
+// isApi3Open tells whether the /api3 route at path can be used without an
+// API key.
+func isApi3Open(method, path string) bool {
+	if path == api3OpenAPIPath {
+		return true
+	}
+	for _, r := range api3Routes {
+		if r.Method == method && r.Path == path {
+			return r.Open
+		}
+	}
+	return false
+}
+
+type api3LookupInput struct {
+	Network string `param:"network"`
+	IP      string `param:"ip"`
+}
+
+func (in *api3LookupInput) Validate() *api3Error {
+	if !Is_valid_ip(in.IP) && !Is_valid_cidr(in.IP) {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidIP, "invalid IP or CIDR: %s", in.IP)
+	}
+	return nil
+}
+
+func (a *ApiData) api3Lookup(c echo.Context, in *api3LookupInput) error {
+	if a.Config.ForbidCIDRLookupsViaAPI {
+		in.IP = strings.Split(in.IP, "/")[0]
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	active, inactive, err := s.CheckGline(in.IP, false)
+	if err != nil {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidIP, "invalid IP or CIDR: %s", in.IP).Send(c)
+	}
+	return api3OK(c, in.Network, s.lookupRetGlineDataList(append(active, inactive...), false, parseAcceptLanguage(c.Request().Header.Get("Accept-Language"))))
+}
+
+func (a *ApiData) api3GlineLookup(c echo.Context) error {
+	var in api3LookupInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	return a.api3Lookup(c, &in)
+}
+
+func (a *ApiData) api3GlineLookupOwnIP(c echo.Context) error {
+	in := api3LookupInput{Network: c.Param("network"), IP: c.RealIP()}
+	if e := in.Validate(); e != nil {
+		return e.Send(c)
+	}
+	return a.api3Lookup(c, &in)
+}
+
+type api3IDLookupInput struct {
+	Network string `param:"network"`
+	ID      string `param:"id"`
+}
+
+func (in *api3IDLookupInput) Validate() *api3Error {
+	if !IsGlineIDFormat(in.ID) {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidID, "invalid gline ID: %s", in.ID)
+	}
+	return nil
+}
+
+func (a *ApiData) api3GlineIDLookup(c echo.Context) error {
+	var in api3IDLookupInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	entries, _ := s.CheckGlineByID(in.ID)
+	return api3OK(c, in.Network, s.lookupRetGlineDataList(entries, true, parseAcceptLanguage(c.Request().Header.Get("Accept-Language"))))
+}
+
+type api3SearchInput struct {
+	Network string `param:"network"`
+	Limit   int    `query:"limit"`
+	Filter  glineFilterParams
+
+	filter *glineFilter
+}
+
+func (in *api3SearchInput) Validate() *api3Error {
+	if in.Limit == 0 {
+		in.Limit = 100
+	}
+	if in.Limit < 1 || in.Limit > 1000 {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidParam, "limit must be between 1 and 1000")
+	}
+	f, err := newGlineFilter(in.Filter)
+	if err != nil {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidParam, "%s", err.Error())
+	}
+	in.filter = f
+	return nil
+}
+
+func (a *ApiData) api3Search(c echo.Context) error {
+	var in api3SearchInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	entries := make([]*glineData, 0)
+	s.forEachGline(func(g *glineData) bool {
+		if in.filter.Match(g) {
+			entries = append(entries, g)
+		}
+		return len(entries) < in.Limit
+	})
+	return api3OK(c, in.Network, s.lookupRetGlineDataList(entries, false, parseAcceptLanguage(c.Request().Header.Get("Accept-Language"))))
+}
+
+type api3StatsInput struct {
+	Network string `param:"network"`
+	Limit   int    `query:"limit"`
+}
+
+func (in *api3StatsInput) Validate() *api3Error {
+	if in.Limit == 0 {
+		in.Limit = 20
+	}
+	if in.Limit < 1 {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidParam, "limit must be positive")
+	}
+	return nil
+}
+
+func (a *ApiData) api3Stats(c echo.Context) error {
+	var in api3StatsInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	return api3OK(c, in.Network, s.GeoStats(in.Limit))
+}
+
+type api3RemglineInput struct {
+	Network string `param:"network" json:"-"`
+	Mask    string `json:"mask"`
+	Message string `json:"message"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+func (in *api3RemglineInput) Validate() *api3Error {
+	if !validMask(in.Mask) {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidMask, "invalid gline mask: %q", in.Mask)
+	}
+	if len(in.Message) > 400 {
+		in.Message = in.Message[:400] + " [...]"
+	}
+	in.Message = strings.ReplaceAll(in.Message, "\n", "|")
+	return nil
+}
+
+func (a *ApiData) api3RemoveGline(c echo.Context) error {
+	var in api3RemglineInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	c.Set(auditTargetKey, in.Mask)
+	if isDryRun(c) {
+		return api3OK(c, in.Network, s.dryRunRemoval(in.Mask, "", in.Message))
+	}
+	if e := s.api3Connected(); e != nil {
+		return e.Send(c)
+	}
+	key, _ := c.Get(apiKeyNameKey).(string)
+	if pending := s.holdRemoval(in.Mask, "", key); pending != nil {
+		s.auditedAnnounce(c, announceRemoval, in.Message)
+		return api3Accepted(c, in.Network, pending)
+	}
+	auditLines(c, s.Remover.Lines(in.Mask, "")...)
+	s.auditedAnnounce(c, announceRemoval, in.Message)
+	if err := s.confirmedRemoval(in.Mask, ""); err != nil {
+		return newApi3Error(removalStatus(err), api3ErrRemovalFailed, "removal via %s failed: %s", s.Remover, err.Error()).Send(c)
+	}
+	return api3OK(c, in.Network, "removal confirmed")
+}
+
+type api3CommandInput struct {
+	Network string `param:"network" json:"-"`
+	Command string `json:"command"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+func (in *api3CommandInput) Validate() *api3Error {
+	if strings.TrimSpace(in.Command) == "" || strings.ContainsAny(in.Command, "\r\n") {
+		return newApi3Error(http.StatusBadRequest, api3ErrInvalidParam, "command must be a single, non-empty line")
+	}
+	return nil
+}
+
+func (a *ApiData) api3SendCommand(c echo.Context) error {
+	var in api3CommandInput
+	if e := api3Bind(c, &in); e != nil {
+		return e.Send(c)
+	}
+	s, e := api3Server(in.Network)
+	if e != nil {
+		return e.Send(c)
+	}
+	if isDryRun(c) {
+		return api3OK(c, in.Network, s.dryRunCommand(in.Command))
+	}
+	if e := s.api3Connected(); e != nil {
+		return e.Send(c)
+	}
+	s.Conn.Raw(in.Command)
+	auditLines(c, in.Command)
+	return api3OK(c, in.Network, "command sent")
+}
+
+// api3OpenAPI serves the OpenAPI document. Unlike the other endpoints, it
+// isn't wrapped in an api3Response, so that tools can read it as is.
+func (a *ApiData) api3OpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument())
+}
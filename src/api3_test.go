@@ -0,0 +1,103 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestApi3Envelope(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "api3net", Server: "hidden.undernet.org", Nick: "GL31"})
+	s.NetworkName = "api3net"
+	addTestGline(s, "*@10.3.0.1", 4102444800, 1000, "drone (P540)", true)
+	e := newApi(Configuration{ApiKey: "secret"})
+
+	cases := []struct {
+		method, path, body, key string
+		status                  int
+		code                    string // expected error code, "" for success
+		count                   int
+	}{
+		{"GET", "/api3/glinelookup/api3net/10.3.0.1", "", "", 200, "", 1},
+		{"GET", "/api3/glinelookup/api3net/10.4.0.1", "", "", 200, "", 0},
+		{"GET", "/api3/glinelookup/api3net/not-an-ip", "", "", 400, api3ErrInvalidIP, 0},
+		{"GET", "/api3/glinelookup/nonet/10.3.0.1", "", "", 404, api3ErrNetworkNotFound, 0},
+		{"GET", "/api3/glineidlookup/api3net/1.2.3.4", "", "", 400, api3ErrInvalidID, 0},
+		{"GET", "/api3/search/api3net?policy=P540", "", "", 401, api3ErrUnauthorized, 0},
+		{"GET", "/api3/search/api3net?policy=P540", "", "secret", 200, "", 1},
+		{"GET", "/api3/search/api3net?limit=5000", "", "secret", 400, api3ErrInvalidParam, 0},
+		{"GET", "/api3/search/api3net?level=x", "", "secret", 400, api3ErrInvalidParam, 0},
+		{"POST", "/api3/remgline/api3net", `{"mask":"10.3.0.1"}`, "secret", 400, api3ErrInvalidMask, 0},
+		{"POST", "/api3/remgline/api3net", `{"mask":"*@10.3.0.1"}`, "secret", 503, api3ErrNotConnected, 0},
+		{"POST", "/api3/sendcommand/api3net", `{"command":"PRIVMSG #a :x\r\nQUIT"}`, "secret", 400, api3ErrInvalidParam, 0},
+		{"GET", "/api3/nothing", "", "secret", 404, api3ErrNotFound, 0},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if c.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		if c.key != "" {
+			r.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		var resp struct {
+			Data  json.RawMessage `json:"data"`
+			Error *api3Error      `json:"error"`
+			Meta  *api3Meta       `json:"meta"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s = %d %s: not an envelope", c.method, c.path, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != c.status {
+			t.Errorf("%s %s = %d %s. Want status %d", c.method, c.path, w.Code, w.Body.String(), c.status)
+			continue
+		}
+		if c.code != "" {
+			if resp.Error == nil || resp.Error.Code != c.code {
+				t.Errorf("%s %s = %s. Want error %s", c.method, c.path, w.Body.String(), c.code)
+			}
+			continue
+		}
+		if resp.Error != nil || resp.Meta == nil || resp.Meta.Count == nil || *resp.Meta.Count != c.count {
+			t.Errorf("%s %s = %s. Want %d results", c.method, c.path, w.Body.String(), c.count)
+		}
+	}
+}
+
+func TestApi3OpenAPI(t *testing.T) {
+	e := newApi(Configuration{ApiKey: "secret"})
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/api3/openapi.json", nil))
+	var doc struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || doc.OpenAPI == "" {
+		t.Fatalf("openapi.json = %d %s", w.Code, w.Body.String())
+	}
+	for _, r := range api3Routes {
+		path := reEchoParam.ReplaceAllString(r.Path, "{$1}")
+		op, ok := doc.Paths[path][strings.ToLower(r.Method)]
+		if !ok {
+			t.Errorf("openapi.json has no %s %s", r.Method, path)
+			continue
+		}
+		if _, secured := op["security"]; secured == r.Open {
+			t.Errorf("openapi.json: %s %s security = %v. Want open = %v", r.Method, path, secured, r.Open)
+		}
+	}
+	responses, _ := doc.Paths["/api3/remgline/{network}"]["post"]["responses"].(map[string]any)
+	if held, _ := json.Marshal(responses["202"]); !strings.Contains(string(held), `"requestedby"`) {
+		t.Errorf("openapi.json: remgline 202 = %s. Want the pending removal", held)
+	}
+	schema, _ := json.Marshal(doc.Paths["/api3/glinelookup/{network}/{ip}"]["get"]["responses"])
+	for _, field := range []string{`"mask"`, `"expirets"`, `"explanation"`, `"asn"`} {
+		if !strings.Contains(string(schema), field) {
+			t.Errorf("openapi.json: gline schema has no %s field", field)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// ApprovalConfig lists the glines whose removal through the API must be
+// approved by a second person: another API key, or an IRC user allowed to
+// use !approve.
+type ApprovalConfig struct {
+	Rules []ApprovalRule
+	// Minutes before a pending removal is dropped. Defaults to 60.
+	ExpireMinutes int
+}
+
+// ApprovalRule matches glines by setter and reason. A rule without any
+// criteria matches every gline. Glines listed by the server at connection
+// time have no known setter: they match rules with Setters.
+type ApprovalRule struct {
+	Name          string
+	Setters       []string // globs matched against the server that set the gline
+	ReasonPattern string   // regular expression matched against the reason
+	reasonRegex   *regexp.Regexp
+}
+
+func compileApprovals(cfg *ApprovalConfig) {
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if r.ReasonPattern != "" {
+			r.reasonRegex = regexp.MustCompile(r.ReasonPattern)
+		}
+	}
+}
+
+func (r *ApprovalRule) matches(g *glineData) bool {
+	if r.reasonRegex != nil && !r.reasonRegex.MatchString(g.Reason()) {
+		return false
+	}
+	if len(r.Setters) == 0 || g.Setter() == "" {
+		return true
+	}
+	for _, m := range r.Setters {
+		if MatchMask(m, g.Setter()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *ApprovalConfig) expiry() time.Duration {
+	if cfg.ExpireMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(cfg.ExpireMinutes) * time.Minute
+}
+
+// pendingRemoval is a removal waiting for approval.
+type pendingRemoval = client.PendingRemoval
+
+type approvalQueue struct {
+	mu      sync.Mutex
+	lastID  int
+	pending map[int]*pendingRemoval
+}
+
+func newApprovalQueue() *approvalQueue {
+	return &approvalQueue{pending: make(map[int]*pendingRemoval)}
+}
+
+func (q *approvalQueue) add(p *pendingRemoval) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastID++
+	p.ID = q.lastID
+	q.pending[p.ID] = p
+}
+
+// take removes and returns the pending removal id, if it exists.
+func (q *approvalQueue) take(id int) *pendingRemoval {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := q.pending[id]
+	delete(q.pending, id)
+	return p
+}
+
+func (q *approvalQueue) get(id int) *pendingRemoval {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending[id]
+}
+
+// List returns the pending removals, oldest first.
+func (q *approvalQueue) List() []*pendingRemoval {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := make([]*pendingRemoval, 0, len(q.pending))
+	for _, p := range q.pending {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// unknownGlineRule holds the removals of glines that aren't known, or
+// whose mask isn't valid, when approval rules are configured: what they
+// would match can't be told.
+var unknownGlineRule = ApprovalRule{Name: "unknown gline"}
+
+// approvalRule returns the rule requiring an approval to remove mask, or
+// nil if it can be removed right away.
+func (s *serverData) approvalRule(mask string) *ApprovalRule {
+	rules := s.Config.Approvals.Rules
+	if len(rules) == 0 {
+		return nil
+	}
+	norm, ipNet, ok := normalizeMask(mask)
+	if !ok {
+		return &unknownGlineRule
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g := s.findGline(*ipNet, norm)
+	if g == nil {
+		return &unknownGlineRule
+	}
+	for i := range rules {
+		if rules[i].matches(g) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// holdRemoval queues the removal of mask for approval if a rule requires
+// it, and returns the pending removal. It returns nil if the removal can go
+// ahead.
+func (s *serverData) holdRemoval(mask, reason, requestedBy string) *pendingRemoval {
+	rule := s.approvalRule(mask)
+	if rule == nil {
+		return nil
+	}
+	now := time.Now()
+	expiry := s.Config.Approvals.expiry()
+	p := &pendingRemoval{
+		Mask:        mask,
+		Reason:      reason,
+		Rule:        rule.Name,
+		RequestedBy: requestedBy,
+		CreatedTS:   now.Unix(),
+		ExpireTS:    now.Add(expiry).Unix(),
+	}
+	s.Approvals.add(p)
+	time.AfterFunc(expiry, func() { s.expireRemoval(p.ID) })
+	s.announce(announceRemoval, fmt.Sprintf("Removal #%d of %s requested by %s needs an approval (%s): !approve %d", p.ID, p.Mask, requestedBy, rule.Name, p.ID))
+	return p
+}
+
+// removeGline removes mask the way the API does: held for approval if a
+// rule requires it, requested right away otherwise.
+func (s *serverData) removeGline(mask, reason, requestedBy string) *pendingRemoval {
+	if p := s.holdRemoval(mask, reason, requestedBy); p != nil {
+		return p
+	}
+	s.requestRemoval(mask, reason)
+	return nil
+}
+
+// expireRemoval drops the pending removal id, unless it was approved.
+func (s *serverData) expireRemoval(id int) bool {
+	p := s.Approvals.take(id)
+	if p == nil {
+		return false
+	}
+	s.announce(announceRemoval, fmt.Sprintf("Removal #%d of %s expired without approval.", p.ID, p.Mask))
+	return true
+}
+
+// approveRemoval removes the gline of the pending removal id, approved by
+// approver.
+func (s *serverData) approveRemoval(id int, approver string) *pendingRemoval {
+	p := s.takeApproved(id, approver)
+	if p != nil {
+		s.requestRemoval(p.Mask, p.Reason)
+	}
+	return p
+}
+
+// takeApproved takes the pending removal id out of the queue, approved by
+// approver. The caller removes the gline.
+func (s *serverData) takeApproved(id int, approver string) *pendingRemoval {
+	p := s.Approvals.take(id)
+	if p == nil {
+		return nil
+	}
+	s.announce(announceRemoval, fmt.Sprintf("Removal #%d of %s approved by %s.", p.ID, p.Mask, approver))
+	return p
+}
+
+func init() {
+	registerBotCommand(&botCommand{
+		Name:       "approve",
+		Args:       "<id>",
+		Help:       "Approve a pending gline removal.",
+		MinArgs:    1,
+		MaxArgs:    1,
+		Privileged: true,
+		Run:        (*serverData).cmdApprove,
+	})
+}
+
+func (s *serverData) cmdApprove(inv *invocation, args []string) {
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		s.reply(inv, fmt.Sprintf("Invalid id: %s", args[0]))
+		return
+	}
+	if s.approveRemoval(id, inv.Nick) == nil {
+		s.reply(inv, fmt.Sprintf("No pending removal #%d", id))
+	}
+}
+
+type api_approval_struct struct {
+	Network string `param:"network"`
+	ID      int    `param:"id"`
+}
+
+// getApprovalsApi lists the pending removals.
+func (a *ApiData) getApprovalsApi(c echo.Context) error {
+	var in api_approval_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	return c.JSON(http.StatusOK, s.Approvals.List())
+}
+
+// approveApi approves a pending removal. The key must differ from the one
+// that requested it.
+func (a *ApiData) approveApi(c echo.Context) error {
+	var in api_approval_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	p := s.Approvals.get(in.ID)
+	if p == nil {
+		return c.JSON(http.StatusNotFound, "Pending removal not found")
+	}
+	c.Set(auditTargetKey, p.Mask)
+	key, _ := c.Get(apiKeyNameKey).(string)
+	if strings.EqualFold(key, p.RequestedBy) {
+		return c.JSON(http.StatusForbidden, "Approval must come from another key")
+	}
+	if isDryRun(c) {
+		r := s.dryRunRemoval(p.Mask, p.Reason, "")
+		r.Approval = ""
+		r.Lines = s.Remover.Lines(p.Mask, p.Reason)
+		return c.JSON(http.StatusOK, r)
+	}
+	if !s.Conn.Connected() {
+		return c.JSON(http.StatusServiceUnavailable, "Server not connected")
+	}
+	if p = s.takeApproved(in.ID, "key "+key); p == nil {
+		return c.JSON(http.StatusNotFound, "Pending removal not found")
+	}
+	auditLines(c, s.Remover.Lines(p.Mask, p.Reason)...)
+	if err := s.confirmedRemoval(p.Mask, p.Reason); err != nil {
+		return c.JSON(removalStatus(err), fmt.Sprintf("Removal via %s failed: %s", s.Remover, err.Error()))
+	}
+	return c.JSON(http.StatusOK, "Removal confirmed")
+}
@@ -0,0 +1,104 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestHoldRemoval(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "approvalnet", Server: "hidden.undernet.org", Nick: "GLP1",
+		Approvals: ApprovalConfig{Rules: []ApprovalRule{
+			{Name: "auto", ReasonPattern: "^AUTO "},
+			{Name: "euworld", Setters: []string{"euworld.*"}},
+		}}})
+	s.NetworkName = "approvalnet"
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.94.0.1", now+3600, now, "AUTO [0] drone", true)
+	active := true
+	_, ipNet, _ := net.ParseCIDR("10.94.0.2/32")
+	s.AddOrUpdateGline(*ipNet, "*", "*@10.94.0.2", "uworld.undernet.org", now+3600, now, "spam", &active, "")
+	_, ipNet, _ = net.ParseCIDR("10.94.1.0/24")
+	s.AddOrUpdateGline(*ipNet, "*", "*@10.94.1.0/24", "euworld.undernet.org", now+3600, now, "spam", &active, "")
+	// Listed at connection time, without its setter.
+	handleGline280(s.Conn, &irc.Line{Raw: fmt.Sprintf(":hidden.undernet.org 280 GLP1 *@10.94.0.4 %d %d %d * + :spam", now+3600, now, now+3600)})
+
+	var tests = []struct {
+		mask string
+		rule string
+	}{
+		{"*@10.94.0.1", "auto"},
+		{"*@10.94.0.2", ""},
+		{"*@10.94.0.2/32", ""},
+		{"*@10.94.1.0/24", "euworld"},
+		{"*@10.94.0.4", "euworld"},
+		{"*@10.94.0.3", "unknown gline"},
+		{"not-a-mask", "unknown gline"},
+	}
+	for _, tt := range tests {
+		rule := ""
+		if r := s.approvalRule(tt.mask); r != nil {
+			rule = r.Name
+		}
+		if rule != tt.rule {
+			t.Errorf(`approvalRule(%s) = %q. Want %q`, tt.mask, rule, tt.rule)
+		}
+	}
+
+	p := s.holdRemoval("*@10.94.0.1", "", "abuse")
+	if p == nil || p.ID != 1 || p.Rule != "auto" || p.RequestedBy != "abuse" {
+		t.Fatalf(`holdRemoval() = %+v. Want pending #1`, p)
+	}
+	e := newApi(Configuration{ApiKey: "secret", ApiKeys: map[string]string{"abuse": "other"}})
+	approve := func(key string) int {
+		r := httptest.NewRequest("POST", "/api2/approvals/approvalnet/1", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := approve("other"); code != http.StatusForbidden {
+		t.Errorf(`approval by the requesting key = %d. Want 403`, code)
+	}
+	r := httptest.NewRequest("GET", "/api2/approvals/approvalnet", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	var list []pendingRemoval
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Mask != "*@10.94.0.1" {
+		t.Errorf(`approvals = %s. Want the pending removal`, w.Body.String())
+	}
+	if s.approveRemoval(1, "oper") == nil {
+		t.Errorf(`approveRemoval(1) = nil. Want the pending removal`)
+	}
+	if s.approveRemoval(1, "oper") != nil || len(s.Approvals.List()) != 0 {
+		t.Errorf(`pending removal #1 still there after approval`)
+	}
+	if code := approve("secret"); code != http.StatusNotFound {
+		t.Errorf(`approval of an approved removal = %d. Want 404`, code)
+	}
+}
+
+func TestPendingRemovalExpires(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "approvalnet2", Server: "hidden.undernet.org", Nick: "GLP2",
+		Approvals: ApprovalConfig{Rules: []ApprovalRule{{Name: "all"}}}})
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.95.0.1", now+3600, now, "spam", true)
+	p := s.holdRemoval("*@10.95.0.1", "", "abuse")
+	if p == nil || p.ExpireTS != p.CreatedTS+3600 {
+		t.Fatalf(`holdRemoval() = %+v. Want expiring in an hour`, p)
+	}
+	if !s.expireRemoval(p.ID) || s.approveRemoval(p.ID, "oper") != nil {
+		t.Errorf(`pending removal #%d approved after it expired`, p.ID)
+	}
+	p = s.holdRemoval("*@10.95.0.1", "", "abuse")
+	if s.approveRemoval(p.ID, "oper") == nil || s.expireRemoval(p.ID) {
+		t.Errorf(`pending removal #%d expired after it was approved`, p.ID)
+	}
+}
@@ -0,0 +1,311 @@
+package ircglineapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// auditRecord is one line of the audit log, stored as JSON.
+type auditRecord = client.AuditRecord
+
+// auditLog appends records to a file, one JSON object per line. A nil
+// *auditLog discards everything, so callers don't need to check whether
+// auditing is configured.
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	filename string
+}
+
+func openAuditLog(filename string) (*auditLog, error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: f, filename: filename}, nil
+}
+
+func (a *auditLog) Write(rec auditRecord) {
+	if a == nil {
+		return
+	}
+	if rec.Time == 0 {
+		rec.Time = time.Now().Unix()
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("auditLog.Write():", err.Error())
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(b, '\n')); err != nil {
+		log.Println("auditLog.Write():", err.Error())
+	}
+}
+
+// auditFilter selects records in Query. Zero fields match everything.
+type auditFilter struct {
+	Network string
+	Key     string
+	Mask    string // matched against the target and the parameters, wildcards allowed
+	Since   int64
+	Until   int64
+}
+
+func (f *auditFilter) match(rec *auditRecord) bool {
+	if f.Network != "" && !strings.EqualFold(f.Network, rec.Network) {
+		return false
+	}
+	if f.Key != "" && !strings.EqualFold(f.Key, rec.Key) {
+		return false
+	}
+	if f.Since != 0 && rec.Time < f.Since {
+		return false
+	}
+	if f.Until != 0 && rec.Time > f.Until {
+		return false
+	}
+	if f.Mask == "" || MatchMask(f.Mask, rec.Target) {
+		return true
+	}
+	for _, v := range rec.Params {
+		if MatchMask(f.Mask, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns up to limit records matching f, the most recent first.
+func (a *auditLog) Query(f auditFilter, limit int) ([]auditRecord, error) {
+	file, err := os.Open(a.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var list []auditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if f.match(&rec) {
+			list = append(list, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// Context keys set for the audit of API calls.
+const (
+	apiKeyNameKey     = "apikeyname"
+	auditLinesKey     = "auditlines"
+	auditTargetKey    = "audittarget"
+	defaultApiKeyName = "default"
+)
+
+// auditLines records IRC lines sent on behalf of an API call.
+func auditLines(c echo.Context, lines ...string) {
+	list, _ := c.Get(auditLinesKey).([]string)
+	c.Set(auditLinesKey, append(list, lines...))
+}
+
+// auditApiCalls writes every API call that isn't a GET to the API audit
+// log.
+func (a *ApiData) auditApiCalls(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return next(c)
+		}
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(req.Body)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		status := c.Response().Status
+		outcome := "ok"
+		if status >= 400 {
+			outcome = "error"
+		} else if isDryRun(c) {
+			outcome = "dryrun"
+		}
+		lines, _ := c.Get(auditLinesKey).([]string)
+		target, _ := c.Get(auditTargetKey).(string)
+		if target == "" {
+			target = c.Param("name")
+		}
+		key, _ := c.Get(apiKeyNameKey).(string)
+		a.Audit.Write(auditRecord{
+			Network: auditNetwork(c),
+			Source:  "api",
+			Actor:   c.RealIP(),
+			Target:  target,
+			Command: req.Method + " " + c.Path(),
+			Outcome: outcome,
+			Key:     key,
+			Params:  auditParams(c, body),
+			Lines:   lines,
+			Status:  status,
+		})
+		return nil
+	}
+}
+
+// auditUnauthorized records a call made with a missing or invalid API key,
+// whatever its method.
+func (a *ApiData) auditUnauthorized(c echo.Context, status int) {
+	a.Audit.Write(auditRecord{
+		Network: auditNetwork(c),
+		Source:  "api",
+		Actor:   c.RealIP(),
+		Command: c.Request().Method + " " + c.Path(),
+		Outcome: "unauthorized",
+		Status:  status,
+	})
+}
+
+// auditNetwork returns the name of the network of a call, as configured if
+// it is known.
+func auditNetwork(c echo.Context) string {
+	if s := servers.GetServerInfosByNetwork(c.Param("network")); s != nil {
+		return s.Config.Network
+	}
+	return c.Param("network")
+}
+
+// auditParams collects the path, query and body parameters of a call.
+func auditParams(c echo.Context, body []byte) map[string]string {
+	params := make(map[string]string)
+	for i, name := range c.ParamNames() {
+		params[name] = c.ParamValues()[i]
+	}
+	for name, values := range c.QueryParams() {
+		params[name] = strings.Join(values, ",")
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		for name, v := range fields {
+			if str, ok := v.(string); ok {
+				params[name] = str
+			} else {
+				b, _ := json.Marshal(v)
+				params[name] = string(b)
+			}
+		}
+	} else if form, err := url.ParseQuery(string(body)); err == nil {
+		for name, values := range form {
+			params[name] = strings.Join(values, ",")
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+type api_audit_struct struct {
+	Network string `param:"network"`
+	Key     string `query:"key"`
+	Mask    string `query:"mask"`
+	Since   string `query:"since"`
+	Until   string `query:"until"`
+	Limit   int    `query:"limit"`
+}
+
+// auditApi returns the audit log, the most recent records first.
+func (a *ApiData) auditApi(c echo.Context) error {
+	var in api_audit_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if a.Audit == nil {
+		return c.JSON(http.StatusNotFound, "Audit log not enabled")
+	}
+	f := auditFilter{Network: s.Config.Network, Key: in.Key, Mask: in.Mask}
+	var err error
+	if in.Since != "" {
+		if f.Since, err = parseAtTime(in.Since); err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid since")
+		}
+	}
+	if in.Until != "" {
+		if f.Until, err = parseAtTime(in.Until); err != nil {
+			return c.JSON(http.StatusBadRequest, "Invalid until")
+		}
+	}
+	if in.Limit <= 0 {
+		in.Limit = 100
+	} else if in.Limit > 1000 {
+		in.Limit = 1000
+	}
+	list, err := a.Audit.Query(f, in.Limit)
+	if err != nil {
+		log.Println("auditApi():", err.Error())
+		return c.JSON(http.StatusInternalServerError, "Can't read the audit log")
+	}
+	if list == nil {
+		list = []auditRecord{}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// auditedAnnounce announces msg on behalf of an API call.
+func (s *serverData) auditedAnnounce(c echo.Context, class, msg string) {
+	auditLines(c, s.announce(class, msg)...)
+}
+
+// apiAuditLog returns the file API calls are logged to.
+func (cfg *Configuration) apiAuditLog() string {
+	if cfg.ApiAuditLog == "" {
+		return "api-audit.log"
+	}
+	return cfg.ApiAuditLog
+}
+
+// apiKeyName returns the name of key, or "" if it isn't valid.
+func (cfg *Configuration) apiKeyName(key string) string {
+	if key == "" {
+		return ""
+	}
+	if key == cfg.ApiKey {
+		return defaultApiKeyName
+	}
+	for name, k := range cfg.ApiKeys {
+		if key == k {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditApiCalls(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "auditnet", Server: "hidden.undernet.org", Nick: "GLA1"})
+	s.NetworkName = "auditnet"
+	e := newApi(Configuration{ApiKey: "secret", ApiKeys: map[string]string{"abuse": "other"},
+		ApiAuditLog: filepath.Join(t.TempDir(), "api-audit.log")})
+
+	calls := []struct {
+		method, path, contentType, body, key string
+	}{
+		{"POST", "/api2/sendcommand/auditnet", "application/x-www-form-urlencoded", "command=" + url.QueryEscape("GLINE +*@10.1.2.3 1d :spam"), "other"},
+		{"POST", "/api2/remgline/auditnet", "application/x-www-form-urlencoded", "glinemask=" + url.QueryEscape("*@10.1.2.3"), "secret"},
+		{"POST", "/api3/remgline/auditnet", "application/json", `{"mask":"*@10.9.9.9"}`, "other"},
+		{"POST", "/api2/sendcommand/auditnet", "", "", "wrong"},
+		{"POST", "/api2/sendcommand/auditnet", "", "", ""},
+		{"GET", "/api2/status/auditnet", "", "", "secret"},
+		{"GET", "/api3/search/auditnet", "", "", ""},
+	}
+	for _, c := range calls {
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		if c.contentType != "" {
+			r.Header.Set("Content-Type", c.contentType)
+		}
+		if c.key != "" {
+			r.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		e.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	query := func(q string) []auditRecord {
+		r := httptest.NewRequest("GET", "/api2/audit/auditnet?"+q, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		var list []auditRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf(`audit?%s = %d %s`, q, w.Code, w.Body.String())
+		}
+		return list
+	}
+	// GETs are only audited when the key is missing or invalid.
+	list := query("")
+	if len(list) != 6 || list[3].Command != "POST /api3/remgline/:network" {
+		t.Fatalf(`audit = %+v. Want the 3 POSTs and 3 unauthorized calls, the most recent first`, list)
+	}
+	// On /api2, a missing key is a 400 and an invalid one a 401.
+	for i, status := range []int{401, 400, 401} {
+		if rec := list[i]; rec.Outcome != "unauthorized" || rec.Status != status || rec.Key != "" {
+			t.Errorf(`audit[%d] = %+v. Want an unauthorized call, status %d`, i, rec, status)
+		}
+	}
+	list = query("key=abuse")
+	if len(list) != 2 {
+		t.Fatalf(`audit?key=abuse = %+v. Want 2 records`, list)
+	}
+	rec := list[1]
+	if rec.Source != "api" || rec.Actor != "192.0.2.1" || rec.Params["command"] != "GLINE +*@10.1.2.3 1d :spam" || rec.Status != 503 || rec.Outcome != "error" {
+		t.Errorf(`audit record = %+v`, rec)
+	}
+	if list := query("mask=" + url.QueryEscape("*@10.1.2.*")); len(list) != 2 {
+		t.Errorf(`audit?mask=*@10.1.2.* = %+v. Want 2 records`, list)
+	}
+	if list := query("until=1000"); len(list) != 0 {
+		t.Errorf(`audit?until=1000 = %+v. Want none`, list)
+	}
+}
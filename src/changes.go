@@ -0,0 +1,157 @@
+package ircglineapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ChangesConfig sizes the change feed served at /api2/changes.
+type ChangesConfig struct {
+	// Number of changes kept in memory. Consumers whose cursor is older
+	// than the oldest change kept must resync from a full export.
+	// Defaults to 100000.
+	MaxEntries int
+}
+
+func (c ChangesConfig) maxEntries() int {
+	if c.MaxEntries <= 0 {
+		return 100000
+	}
+	return c.MaxEntries
+}
+
+// Header holding the change cursor as of the start of an export.
+const changesCursorHeader = "X-Changes-Cursor"
+
+// changeEntry is one numbered change of the feed.
+type changeEntry struct {
+	Seq   uint64
+	Kind  string
+	TS    int64
+	Gline *glineData // snapshot
+}
+
+// changeLog numbers the changes applied to the gline store and keeps the
+// latest of them. Sequence numbers start from the startup time in
+// microseconds, so they keep increasing across restarts and cursors from a
+// previous run are detected as stale.
+type changeLog struct {
+	mu      sync.RWMutex
+	max     int
+	seq     uint64
+	entries []changeEntry
+}
+
+func newChangeLog(cfg ChangesConfig) *changeLog {
+	return &changeLog{
+		max: cfg.maxEntries(),
+		seq: uint64(time.Now().UnixMicro()),
+	}
+}
+
+// Append numbers c and adds it to the log. AddOrUpdateGline calls it with
+// s.mu held, so the numbers follow the order changes are applied in.
+func (l *changeLog) Append(c *glineChange) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	c.Seq = l.seq
+	l.entries = append(l.entries, changeEntry{Seq: l.seq, Kind: c.Kind, TS: time.Now().Unix(), Gline: c.Gline})
+	if len(l.entries) > l.max {
+		// Drop a batch at once rather than shifting the slice each time.
+		drop := len(l.entries) - l.max + l.max/10
+		l.entries = append(l.entries[:0:0], l.entries[drop:]...)
+	}
+}
+
+// Cursor returns the sequence number of the latest change.
+func (l *changeLog) Cursor() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.seq
+}
+
+// Since returns up to limit changes following cursor since, and whether
+// more are left. ok is false when changes following since were dropped or
+// since comes from another run, meaning the consumer must resync.
+func (l *changeLog) Since(since uint64, limit int) (entries []changeEntry, more bool, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if since > l.seq {
+		return nil, false, false
+	}
+	first := l.seq + 1
+	if len(l.entries) > 0 {
+		first = l.entries[0].Seq
+	}
+	if since+1 < first {
+		return nil, false, false
+	}
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].Seq > since })
+	entries = l.entries[i:]
+	if len(entries) > limit {
+		entries, more = entries[:limit], true
+	}
+	return append([]changeEntry(nil), entries...), more, true
+}
+
+type api_changes_struct struct {
+	Network string `param:"network"`
+	Since   string `query:"since"`
+	Limit   int    `query:"limit"`
+}
+
+type retChange struct {
+	Seq   uint64        `json:"seq"`
+	Kind  string        `json:"kind"`
+	TS    int64         `json:"ts"`
+	Gline *RetGlineData `json:"gline"`
+}
+
+type retChanges struct {
+	Changes []retChange `json:"changes"`
+	Cursor  uint64      `json:"cursor"`
+	More    bool        `json:"more"`
+}
+
+// changesApi returns the changes following ?since=, oldest first, and the
+// cursor to pass next time. Without ?since=, it only returns the current
+// cursor. It answers 410 when the consumer must resync: fetch a full
+// export, then follow the feed from the cursor in its X-Changes-Cursor
+// header.
+func (a *ApiData) changesApi(c echo.Context) error {
+	var in api_changes_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if in.Since == "" {
+		return c.JSON(http.StatusOK, &retChanges{Changes: []retChange{}, Cursor: s.Changes.Cursor()})
+	}
+	since, err := strconv.ParseUint(in.Since, 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid cursor")
+	}
+	if in.Limit <= 0 || in.Limit > 10000 {
+		in.Limit = 1000
+	}
+	entries, more, ok := s.Changes.Since(since, in.Limit)
+	if !ok {
+		return c.JSON(http.StatusGone, "Resync required")
+	}
+	ret := &retChanges{Changes: make([]retChange, 0, len(entries)), Cursor: since, More: more}
+	for _, e := range entries {
+		g := buildRetGlineDataList([]*glineData{e.Gline}, false)[0]
+		ret.Changes = append(ret.Changes, retChange{Seq: e.Seq, Kind: e.Kind, TS: e.TS, Gline: g})
+		ret.Cursor = e.Seq
+	}
+	return c.JSON(http.StatusOK, ret)
+}
@@ -0,0 +1,91 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestChangeLogSince(t *testing.T) {
+	l := newChangeLog(ChangesConfig{MaxEntries: 10})
+	start := l.Cursor()
+	for i := 0; i < 25; i++ {
+		l.Append(&glineChange{Kind: glineAdded, Gline: &glineData{mask: "*@10.0.0." + strconv.Itoa(i)}})
+	}
+	cursor := l.Cursor()
+	if cursor != start+25 {
+		t.Errorf(`Cursor() = start+%d. Want start+25`, cursor-start)
+	}
+	entries, more, ok := l.Since(cursor-3, 2)
+	if !ok || !more || len(entries) != 2 || entries[0].Seq != cursor-2 || entries[1].Seq != cursor-1 {
+		t.Errorf(`Since(cursor-3, 2) = %d entries, more %t, ok %t. Want cursor-2 and cursor-1, more`, len(entries), more, ok)
+	}
+	if entries, _, ok := l.Since(cursor, 10); !ok || len(entries) != 0 {
+		t.Errorf(`Since(cursor) = %d entries, ok %t. Want none, ok`, len(entries), ok)
+	}
+	for _, since := range []uint64{start, cursor - 20, cursor + 1, 0} {
+		if _, _, ok := l.Since(since, 10); ok {
+			t.Errorf(`Since(start+%d) ok. Want a resync`, since-start)
+		}
+	}
+}
+
+func TestChangesApi(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "changesnet", Server: "hidden.undernet.org", Nick: "GLF1"})
+	s.NetworkName = "changesnet"
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/changes/:network", a.changesApi)
+	e.GET("/api2/export/:network", a.exportApi)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	var ret retChanges
+	w := get("/api2/changes/changesnet")
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || w.Code != http.StatusOK {
+		t.Fatalf(`changes = %d %s. Want the current cursor`, w.Code, w.Body.String())
+	}
+	cursor := ret.Cursor
+
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.70.0.1", now+3600, now, "first", true)
+	addTestGline(s, "*@10.80.0.1", now+3600, now, "second", true)
+	addTestGline(s, "*@10.70.0.1", now+7200, now, "first, extended", true)
+
+	if got := get("/api2/export/changesnet").Header().Get(changesCursorHeader); got != strconv.FormatUint(cursor+3, 10) {
+		t.Errorf(`export %s = %s. Want %d`, changesCursorHeader, got, cursor+3)
+	}
+
+	ret = retChanges{}
+	w = get("/api2/changes/changesnet?since=" + strconv.FormatUint(cursor, 10))
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil || len(ret.Changes) != 3 {
+		t.Fatalf(`changes?since=cursor = %d %s. Want 3 changes`, w.Code, w.Body.String())
+	}
+	want := []struct {
+		kind, reason string
+	}{{glineAdded, "first"}, {glineAdded, "second"}, {glineModified, "first, extended"}}
+	for i, c := range ret.Changes {
+		if c.Seq != cursor+uint64(i)+1 || c.Kind != want[i].kind || c.Gline.Reason != want[i].reason {
+			t.Errorf(`change %d = %d %s %s. Want %d %s %s`, i, c.Seq-cursor, c.Kind, c.Gline.Reason, i+1, want[i].kind, want[i].reason)
+		}
+	}
+	if ret.Cursor != cursor+3 || ret.More {
+		t.Errorf(`cursor = cursor+%d, more %t. Want cursor+3, no more`, ret.Cursor-cursor, ret.More)
+	}
+
+	if w := get("/api2/changes/changesnet?since=1"); w.Code != http.StatusGone {
+		t.Errorf(`changes?since=1 = %d. Want %d`, w.Code, http.StatusGone)
+	}
+	if w := get("/api2/changes/changesnet?since=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf(`changes?since=abc = %d. Want %d`, w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,291 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// botCommand is an entry of the bot's command registry.
+type botCommand struct {
+	Name       string
+	Args       string // argument synopsis shown in usage text, e.g. "<IP|CIDR|ID>"
+	Help       string
+	MinArgs    int
+	MaxArgs    int  // the last argument swallows the rest of the line; 0 means no limit
+	Paged      bool // accepts a trailing "page <n>"
+	Privileged bool // denied unless the ACL grants it; always audited
+	Run        func(s *serverData, inv *invocation, args []string)
+}
+
+var botCommands = make(map[string]*botCommand)
+
+// botCommandNames lists the registry in a stable order, for !help.
+var botCommandNames []string
+
+func registerBotCommand(cmd *botCommand) {
+	botCommands[cmd.Name] = cmd
+	botCommandNames = append(botCommandNames, cmd.Name)
+	sort.Strings(botCommandNames)
+}
+
+func lookupBotCommand(name string) *botCommand {
+	return botCommands[strings.ToLower(name)]
+}
+
+func init() {
+	registerBotCommand(&botCommand{
+		Name:    "g",
+		Args:    "<IP|CIDR|ID> [@date]",
+		Help:    "Show the glines matching an IP, a CIDR or a gline ID, or those that matched an IP or a CIDR at a past date, e.g. @2026-10-18 14:30 (UTC).",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Paged:   true,
+		Run:     (*serverData).cmdGline,
+	})
+	registerBotCommand(&botCommand{
+		Name:    "gsearch",
+		Args:    "<reason-regex>",
+		Help:    "Show the active glines whose reason matches a regex (case-insensitive).",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Paged:   true,
+		Run:     (*serverData).cmdGsearch,
+	})
+	registerBotCommand(&botCommand{
+		Name:    "gexpiring",
+		Args:    "<duration>",
+		Help:    "Show the active glines expiring within a duration, e.g. 30m, 6h or 2d.",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Paged:   true,
+		Run:     (*serverData).cmdGexpiring,
+	})
+	registerBotCommand(&botCommand{
+		Name:    "gsetter",
+		Args:    "<server>",
+		Help:    "Show the active glines set by a server. Wildcards are allowed.",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Paged:   true,
+		Run:     (*serverData).cmdGsetter,
+	})
+	registerBotCommand(&botCommand{
+		Name:    "gcount",
+		Args:    "<cidr>",
+		Help:    "Count the glines covering or covered by a CIDR.",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Run:     (*serverData).cmdGcount,
+	})
+	registerBotCommand(&botCommand{
+		Name:       "gremove",
+		Args:       "<mask> <reason>",
+		Help:       "Ask OperServ to remove a gline.",
+		MinArgs:    2,
+		MaxArgs:    2,
+		Privileged: true,
+		Run:        (*serverData).cmdGremove,
+	})
+	registerBotCommand(&botCommand{
+		Name:    "help",
+		Args:    "[command]",
+		Help:    "List the commands, or show how to use one.",
+		MaxArgs: 1,
+		Run:     (*serverData).cmdHelp,
+	})
+	registerBotCommand(&botCommand{
+		Name:       "die",
+		Help:       "Disconnect and stop the bot.",
+		Privileged: true,
+		Run:        func(s *serverData, inv *invocation, args []string) { s.die() },
+	})
+}
+
+func (cmd *botCommand) Usage() string {
+	if cmd.Args == "" {
+		return "!" + cmd.Name
+	}
+	return fmt.Sprintf("!%s %s", cmd.Name, cmd.Args)
+}
+
+// parseArgs splits the words following the command name according to
+// MinArgs/MaxArgs. It returns false if there are too few of them.
+func (cmd *botCommand) parseArgs(words []string) ([]string, bool) {
+	if len(words) < cmd.MinArgs {
+		return nil, false
+	}
+	if cmd.MaxArgs > 0 && len(words) > cmd.MaxArgs {
+		last := strings.Join(words[cmd.MaxArgs-1:], " ")
+		words = append(words[:cmd.MaxArgs-1:cmd.MaxArgs-1], last)
+	}
+	return words, true
+}
+
+// dispatchBotCommand runs the command in inv.Text, if it is one.
+func (s *serverData) dispatchBotCommand(inv *invocation) {
+	w := strings.Fields(inv.Text)
+	if len(w) < 1 || len(w[0]) < 2 || w[0][0] != '!' {
+		return
+	}
+	cmd := lookupBotCommand(w[0][1:])
+	if cmd == nil {
+		return
+	}
+	words := w[1:]
+	inv.Page = 1
+	if n := len(words); cmd.Paged && n >= 2 && strings.EqualFold(words[n-2], "page") {
+		if page, err := strconv.Atoi(words[n-1]); err == nil && page > 0 {
+			inv.Page = page
+			words = words[:n-2]
+		}
+	}
+	inv.Command = strings.Join(append([]string{w[0]}, words...), " ")
+	s.authorize(cmd.Name, cmd.Privileged, inv, func() {
+		args, ok := cmd.parseArgs(words)
+		if !ok {
+			s.reply(inv, "Syntax: "+cmd.Usage())
+			return
+		}
+		cmd.Run(s, inv, args)
+	})
+}
+
+// reply queues msg for the channel or the user the command came from.
+func (s *serverData) reply(inv *invocation, msg ...string) {
+	s.Out.Enqueue(inv.ReplyTarget(), msg...)
+}
+
+// replyGlines sends one numbered line per gline, formatted by format, a
+// page at a time, ending with a hint on how to get the next page. empty is
+// sent instead when there is nothing to show.
+func (s *serverData) replyGlines(inv *invocation, entries []*glineData, format func(*glineData) string, empty string) {
+	if len(entries) == 0 {
+		s.reply(inv, empty)
+		return
+	}
+	lines := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		lines = append(lines, fmt.Sprintf("(%d/%d) %s", i+1, len(entries), format(entry)))
+	}
+	page, more := paginate(lines, inv.Page, s.Config.Output.maxLines())
+	if len(page) == 0 {
+		s.reply(inv, fmt.Sprintf("No page %d: there are only %d results.", inv.Page, len(entries)))
+		return
+	}
+	if more > 0 {
+		page = append(page, fmt.Sprintf("%d more, use %s page %d", more, inv.Command, inv.Page+1))
+	}
+	s.reply(inv, page...)
+}
+
+func (s *serverData) cmdGline(inv *invocation, args []string) {
+	if len(args) > 1 {
+		at, ok := strings.CutPrefix(args[1], "@")
+		if !ok {
+			s.reply(inv, "Syntax: "+lookupBotCommand("g").Usage())
+			return
+		}
+		s.cmdGlineAt(inv, args[0], at)
+		return
+	}
+	var entries []*glineData
+	var err error
+	if IsGlineIDFormat(args[0]) {
+		entries, err = s.CheckGlineByID(args[0])
+	} else {
+		active, inactive, cgErr := s.CheckGline(args[0], false)
+		entries, err = append(active, inactive...), cgErr
+	}
+	if err != nil {
+		return
+	}
+	s.replyGlines(inv, entries, s.formatGlineLineDetailed, fmt.Sprintf("No match: %s", args[0]))
+}
+
+// activeGlinesWhere returns the active glines for which match is true.
+func (s *serverData) activeGlinesWhere(match func(g *glineData) bool) []*glineData {
+	list := make([]*glineData, 0)
+	s.forEachGline(func(g *glineData) bool {
+		if g.IsGlineActive() && match(g) {
+			list = append(list, g)
+		}
+		return true
+	})
+	return list
+}
+
+func (s *serverData) cmdGsearch(inv *invocation, args []string) {
+	re, err := regexp.Compile("(?i)" + args[0])
+	if err != nil {
+		s.reply(inv, fmt.Sprintf("Invalid regex: %s", err.Error()))
+		return
+	}
+	list := s.activeGlinesWhere(func(g *glineData) bool { return re.MatchString(g.Reason()) })
+	s.replyGlines(inv, list, formatGlineLine, fmt.Sprintf("No active gline matches %s", args[0]))
+}
+
+func (s *serverData) cmdGexpiring(inv *invocation, args []string) {
+	d, err := ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		s.reply(inv, fmt.Sprintf("Invalid duration: %s", args[0]))
+		return
+	}
+	limit := time.Now().Add(d).Unix()
+	list := s.activeGlinesWhere(func(g *glineData) bool { return g.ExpireTS() <= limit })
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpireTS() < list[j].ExpireTS() })
+	s.replyGlines(inv, list, formatGlineLine, fmt.Sprintf("No active gline expires within %s", args[0]))
+}
+
+func (s *serverData) cmdGsetter(inv *invocation, args []string) {
+	list := s.activeGlinesWhere(func(g *glineData) bool { return MatchMask(args[0], g.Setter()) })
+	s.replyGlines(inv, list, formatGlineLine, fmt.Sprintf("No active gline set by %s", args[0]))
+}
+
+func (s *serverData) cmdGcount(inv *invocation, args []string) {
+	cidr := AddCidrToIP(args[0])
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		s.reply(inv, fmt.Sprintf("Invalid CIDR: %s", args[0]))
+		return
+	}
+	active, inactive, err := s.CheckGline(cidr, false)
+	if err != nil {
+		s.reply(inv, fmt.Sprintf("Invalid CIDR: %s", args[0]))
+		return
+	}
+	s.reply(inv, fmt.Sprintf("%s: %d active, %d expired or deactivated glines", cidr, len(active), len(inactive)))
+}
+
+func (s *serverData) cmdGremove(inv *invocation, args []string) {
+	mask, reason := args[0], args[1]
+	if !strings.Contains(mask, "@") {
+		s.reply(inv, fmt.Sprintf("Invalid gline mask: %s", mask))
+		return
+	}
+	s.requestRemoval(mask, reason)
+	s.announce(announceRemoval, fmt.Sprintf("Removal of %s requested by %s: %s", mask, inv.Nick, reason))
+	if !strings.EqualFold(inv.Target, s.mainChannel()) {
+		s.reply(inv, fmt.Sprintf("Removal of %s sent to %s.", mask, s.Remover))
+	}
+}
+
+func (s *serverData) cmdHelp(inv *invocation, args []string) {
+	if len(args) > 0 {
+		cmd := lookupBotCommand(strings.TrimPrefix(args[0], "!"))
+		if cmd == nil {
+			s.reply(inv, fmt.Sprintf("Unknown command: %s", args[0]))
+			return
+		}
+		s.reply(inv, fmt.Sprintf("%s - %s", cmd.Usage(), cmd.Help))
+		return
+	}
+	list := make([]string, 0, len(botCommandNames))
+	for _, name := range botCommandNames {
+		list = append(list, "!"+name)
+	}
+	s.reply(inv, fmt.Sprintf("Commands: %s. Use !help <command> for details.", strings.Join(list, " ")))
+}
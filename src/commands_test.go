@@ -0,0 +1,73 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBotCommandParseArgs(t *testing.T) {
+	cmd := lookupBotCommand("GREMOVE")
+	if cmd == nil {
+		t.Fatalf(`lookupBotCommand("GREMOVE") = nil`)
+	}
+	args, ok := cmd.parseArgs(strings.Fields("*@1.2.3.4 false positive, user is legit"))
+	if !ok || len(args) != 2 {
+		t.Fatalf(`parseArgs() = %q, %t. Want 2 args`, args, ok)
+	}
+	if args[0] != "*@1.2.3.4" || args[1] != "false positive, user is legit" {
+		t.Errorf(`parseArgs() = %q. Want mask and the rest of the line as reason`, args)
+	}
+	if _, ok := cmd.parseArgs([]string{"*@1.2.3.4"}); ok {
+		t.Errorf(`parseArgs() accepted a missing reason`)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+	}
+	for _, c := range cases {
+		if res, err := ParseDuration(c.s); err != nil || res != c.want {
+			t.Errorf(`ParseDuration(%q) = %v, %v. Want %v`, c.s, res, err, c.want)
+		}
+	}
+	if _, err := ParseDuration("xd"); err == nil {
+		t.Errorf(`ParseDuration("xd") returned no error`)
+	}
+}
+
+func TestForEachGline(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "undernet", Server: "hidden.undernet.org", Nick: "GLC1"})
+	now := time.Now().Unix()
+	masks := []string{"*@0.0.0.0/1", "*@10.2.0.1", "~*@10.2.0.1", "*@200.1.0.0/16", "*@2a01:cb00::/32", "*@::/0"}
+	// Enough glines for the blocks to be split, and networks covering the
+	// halves.
+	for i := 0; i < 2*walkBlockEntries; i++ {
+		masks = append(masks, fmt.Sprintf("*@10.3.%d.%d", i/256, i%256), fmt.Sprintf("*@2001:db8::%x", i))
+	}
+	masks = append(masks, "*@10.3.0.0/23", "*@2001:db8::/64")
+	for _, m := range masks {
+		addTestGline(s, m, now+3600, now, "test", true)
+	}
+	seen := make(map[string]int)
+	s.forEachGline(func(g *glineData) bool {
+		seen[g.Mask()]++
+		return true
+	})
+	for _, m := range masks {
+		if seen[m] != 1 {
+			t.Errorf(`forEachGline() visited %s %d times. Want 1`, m, seen[m])
+		}
+	}
+	if len(seen) != len(masks) {
+		t.Errorf(`forEachGline() visited %d masks. Want %d`, len(seen), len(masks))
+	}
+}
@@ -24,18 +24,43 @@ func ReadConf(filename string) Configuration {
 type Configuration struct {
 	Network                    string
 	Server                     string
+	Servers                    []string // tried in turn; Server is used if empty
 	Channels                   []string
 	Nick                       string
 	Ident                      string
 	Name                       string
 	ConnectCmds                []string
 	ApiKey                     string
-	ReconnWaitTime             int
+	ApiKeys                    map[string]string // more keys, by name for the audit log; ApiKey is "default"
+	ApiAuditLog                string            // append-only log of API calls, "api-audit.log" by default
+	DryRun                     bool              // API calls say what they would do, and don't do it
+	DryRunKeys                 []string          // names of the keys always in dry-run mode
+	ReconnWaitTime             int               // seconds before the first reconnection attempt
+	ReconnMaxWaitTime          int               // the wait doubles at each failed attempt, up to this
 	OperServNick               string
 	OperServLogin              string
 	AutologinIfOperServMissing bool
-	AuthSuccessfullMsgs        []string
+	AuthSuccessfullMsgs        []string // patterns of OperServ's replies to a successful login
+	AuthFailureMsgs            []string // and to a failed one
+	AuthTimeout                int      // seconds to wait for the reply, 30 by default
 	OperServRemglineCmd        string
 	ForbidCIDRLookupsViaAPI    bool
+	Retention                  RetentionConfig
+	ACL                        ACLConfig
+	Output                     OutputConfig
+	Watchlists                 WatchlistConfig
+	Safeguards                 SafeguardConfig
+	DNSBL                      DNSBLConfig
+	ReasonParser               ReasonParserConfig
+	Explanations               ExplanationConfig
+	GeoIP                      GeoIPConfig
+	History                    HistoryConfig
+	Changes                    ChangesConfig
+	Expiry                     ExpiryConfig
+	Keepalive                  KeepaliveConfig
+	Remover                    RemoverConfig
+	Approvals                  ApprovalConfig
+	Schedule                   ScheduleConfig
+	Announce                   AnnounceConfig
 	Debug                      bool
 }
@@ -0,0 +1,199 @@
+package ircglineapi
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// Connection states, as shown by /api2/status.
+const (
+	connStateConnecting  = "connecting"  // dialing a server
+	connStateRegistering = "registering" // connected, waiting for the welcome
+	connStateConnected   = "connected"
+	connStateWaiting     = "waiting" // backing off before the next attempt
+	connStateStopped     = "stopped"
+)
+
+// connStatus is the state of the connection to IRC.
+type connStatus = client.Status
+
+// connState tracks the connection to IRC. One goroutine, connectLoop, owns
+// the reconnections; IRC callbacks only report to it.
+type connState struct {
+	mu           sync.Mutex
+	status       connStatus
+	next         int           // index of the next server to try
+	disconnected chan struct{} // signaled by the DISCONNECTED handler
+	start        sync.Once
+	stop         sync.Once
+}
+
+func newConnState() *connState {
+	return &connState{
+		status:       connStatus{State: connStateWaiting, Since: time.Now().Unix()},
+		disconnected: make(chan struct{}, 1),
+	}
+}
+
+func (c *connState) Status() connStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (c *connState) set(state, server string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status.State != state {
+		c.status.Since = time.Now().Unix()
+	}
+	c.status.State = state
+	if server != "" {
+		c.status.Server = server
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	if state != connStateWaiting {
+		c.status.NextAttemptTS = 0
+	}
+}
+
+// servers returns the servers to connect to, in rotation order.
+func (cfg *Configuration) servers() []string {
+	if len(cfg.Servers) > 0 {
+		return cfg.Servers
+	}
+	return []string{cfg.Server}
+}
+
+// reconnDelay returns how long to wait before attempt number attempts
+// (1 for the first retry): ReconnWaitTime doubled at each failed attempt,
+// up to ReconnMaxWaitTime, minus up to a half at random so that bots
+// restarted together don't reconnect in lockstep.
+func (cfg *Configuration) reconnDelay(attempts int) time.Duration {
+	initial := time.Duration(cfg.ReconnWaitTime) * time.Second
+	if initial <= 0 {
+		initial = 10 * time.Second
+	}
+	max := time.Duration(cfg.ReconnMaxWaitTime) * time.Second
+	if max <= 0 {
+		max = 10 * time.Minute
+	}
+	if max < initial {
+		max = initial
+	}
+	delay := initial
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Connect starts connecting to IRC in the background, and keeps the bot
+// connected until die is called.
+func (s *serverData) Connect() {
+	s.ConnState.start.Do(func() {
+		go s.connectLoop()
+	})
+}
+
+// connectLoop connects to the configured servers in turn, backing off
+// after each failed attempt or lost connection.
+func (s *serverData) connectLoop() {
+	cs := s.ConnState
+	for {
+		server := cs.server(s.Config.servers())
+		s.OperServ.reset()
+		cs.set(connStateConnecting, server, nil)
+		err := s.Conn.ConnectTo(server)
+		if err == nil {
+			cs.set(connStateRegistering, server, nil)
+			select {
+			case <-cs.disconnected:
+			case <-s.Quit:
+				return
+			}
+			err = errors.New("disconnected")
+		}
+		delay := cs.failed(s.Config, err)
+		log.Printf("Connection to %s: %s. Trying the next server in %s\n", server, err.Error(), delay.Round(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-s.Quit:
+			return
+		}
+	}
+}
+
+// server returns the server to try next.
+func (c *connState) server(list []string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return list[c.next%len(list)]
+}
+
+// failed records a failed attempt or a lost connection, moves on to the
+// next server and returns how long to wait before trying it.
+func (c *connState) failed(cfg *Configuration, err error) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next++
+	c.status.Attempts++
+	delay := cfg.reconnDelay(c.status.Attempts)
+	now := time.Now()
+	c.status.State = connStateWaiting
+	c.status.Since = now.Unix()
+	c.status.LastError = err.Error()
+	c.status.NextAttemptTS = now.Add(delay).Unix()
+	return delay
+}
+
+// connected is called once the server welcomed the bot. The next failure
+// starts over from the initial delay.
+func (c *connState) connected() {
+	c.set(connStateConnected, "", nil)
+	c.mu.Lock()
+	c.status.Attempts = 0
+	c.mu.Unlock()
+}
+
+func handleDisconnect(conn *irc.Conn, line *irc.Line) {
+	s := servers.GetServerInfos(conn)
+	s.Keepalive.stopLoop()
+	select {
+	case s.ConnState.disconnected <- struct{}{}:
+	default:
+	}
+}
+
+type api_status_struct struct {
+	Network string `param:"network"`
+}
+
+// statusApi returns the state of the connection to IRC.
+func (a *ApiData) statusApi(c echo.Context) error {
+	var in api_status_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	st := s.ConnState.Status()
+	auth := s.OperServ.Status()
+	st.OperServ = &auth
+	return c.JSON(http.StatusOK, st)
+}
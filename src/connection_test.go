@@ -0,0 +1,109 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestReconnDelay(t *testing.T) {
+	cfg := &Configuration{ReconnWaitTime: 10, ReconnMaxWaitTime: 60}
+	var tests = []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, 60 * time.Second},
+		{10, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := cfg.reconnDelay(tt.attempts); got < tt.max/2 || got > tt.max {
+				t.Errorf(`reconnDelay(%d) = %s. Want between %s and %s`, tt.attempts, got, tt.max/2, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestConnStateRotation(t *testing.T) {
+	cfg := &Configuration{Server: "a:6667", Servers: []string{"b:6667", "c:6667"}}
+	cs := newConnState()
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, cs.server(cfg.servers()))
+		cs.failed(cfg, errors.New("connection refused"))
+	}
+	if got[0] != "b:6667" || got[1] != "c:6667" || got[2] != "b:6667" {
+		t.Errorf(`servers tried = %q. Want b, c, b`, got)
+	}
+	st := cs.Status()
+	if st.State != connStateWaiting || st.Attempts != 3 || st.LastError != "connection refused" || st.NextAttemptTS == 0 {
+		t.Errorf(`Status() = %+v. Want waiting after 3 attempts`, st)
+	}
+	cs.connected()
+	if st := cs.Status(); st.State != connStateConnected || st.Attempts != 0 || st.NextAttemptTS != 0 {
+		t.Errorf(`Status() = %+v. Want connected, no attempts`, st)
+	}
+}
+
+func TestConnectLoopFailover(t *testing.T) {
+	down, _ := net.Listen("tcp", "127.0.0.1:0")
+	downAddr := down.Addr().String()
+	down.Close()
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	go func() {
+		for {
+			c, err := up.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	s := newTestServer(&Configuration{
+		Network:        "failovernet",
+		Servers:        []string{downAddr, up.Addr().String()},
+		Nick:           "GLR2",
+		ReconnWaitTime: 1,
+	})
+	defer s.Conn.Close()
+	go s.connectLoop()
+	defer close(s.Quit)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := s.ConnState.Status(); st.State == connStateRegistering && st.Server == up.Addr().String() {
+			if st.Attempts != 1 {
+				t.Errorf(`Attempts = %d. Want 1`, st.Attempts)
+			}
+			s.ConnState.connected()
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.NetworkName = "failovernet"
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/status/:network", a.statusApi)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api2/status/failovernet", nil)
+	e.ServeHTTP(w, r)
+	var st connStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil || st.State != connStateConnected || st.Server != up.Addr().String() {
+		t.Errorf(`status = %d %s. Want connected to %s`, w.Code, w.Body.String(), up.Addr().String())
+	}
+}
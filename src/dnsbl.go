@@ -0,0 +1,226 @@
+package ircglineapi
+
+import (
+	"log"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSBLConfig enables the built-in DNSBL server. It answers A and TXT
+// queries for <reversed IPv4>.<zone> and <IPv6 nibbles>.<zone> straight
+// from the gline trie. Only active glines on any user ("*@...") list an IP.
+type DNSBLConfig struct {
+	// Address to listen on over UDP and TCP, e.g. ":5353". Empty disables
+	// the server.
+	Listen string
+	Zone   string
+	// TTL of the answers, in seconds. Defaults to 300.
+	TTL uint32
+	// Return code for glines matching none of the categories. Defaults to
+	// 127.0.0.2.
+	DefaultCode string
+	// The first category whose pattern matches the reason gives the
+	// return code.
+	Categories []DNSBLCategory
+	// Hide the IPs mentioned in gline reasons.
+	RedactReason bool
+}
+
+type DNSBLCategory struct {
+	Name          string
+	ReasonPattern string
+	Code          string
+
+	reasonRegex *regexp.Regexp
+}
+
+func (c DNSBLConfig) ttl() uint32 {
+	if c.TTL == 0 {
+		return 300
+	}
+	return c.TTL
+}
+
+func (c DNSBLConfig) defaultCode() string {
+	if c.DefaultCode == "" {
+		return "127.0.0.2"
+	}
+	return c.DefaultCode
+}
+
+// compileDNSBL compiles the categories' reason patterns and normalizes the
+// zone name. It must be called once before the DNSBL is used.
+func compileDNSBL(cfg *DNSBLConfig) {
+	if cfg.Zone != "" {
+		cfg.Zone = dns.Fqdn(strings.ToLower(cfg.Zone))
+	}
+	for i := range cfg.Categories {
+		c := &cfg.Categories[i]
+		c.reasonRegex = regexp.MustCompile("(?i)" + c.ReasonPattern)
+		if net.ParseIP(c.Code).To4() == nil {
+			log.Fatalf("DNSBL category %s: invalid return code %s", c.Name, c.Code)
+		}
+	}
+}
+
+// code returns the A record returned for g.
+func (c *DNSBLConfig) code(g *glineData) string {
+	for i := range c.Categories {
+		if c.Categories[i].reasonRegex.MatchString(g.Reason()) {
+			return c.Categories[i].Code
+		}
+	}
+	return c.defaultCode()
+}
+
+// dnsblQueryIP extracts the queried IP from a name inside zone, e.g.
+// 4.3.2.1.zone. for 1.2.3.4, or the 32 nibbles of an IPv6 address in
+// reverse order. It returns nil if name isn't such a query.
+func dnsblQueryIP(name, zone string) net.IP {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !strings.HasSuffix(name, "."+zone) {
+		return nil
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."+zone), ".")
+	switch len(labels) {
+	case 4:
+		for i, j := 0, 3; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+		return net.ParseIP(strings.Join(labels, ".")).To4()
+	case 32:
+		var b strings.Builder
+		for i := 31; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return nil
+			}
+			b.WriteString(labels[i])
+			if i%4 == 0 && i > 0 {
+				b.WriteByte(':')
+			}
+		}
+		ip := net.ParseIP(b.String())
+		if ip == nil || ip.To4() != nil {
+			return nil
+		}
+		return ip
+	}
+	return nil
+}
+
+// Matches what may be an IP or a CIDR. Matches are checked with net.ParseIP
+// before being redacted, so that e.g. times are left alone.
+var reDNSBLRedact = regexp.MustCompile(`[0-9A-Fa-f.:]*[.:][0-9A-Fa-f.:]+(?:/\d+)?`)
+
+func redactIPs(s string) string {
+	return reDNSBLRedact.ReplaceAllStringFunc(s, func(m string) string {
+		if net.ParseIP(strings.SplitN(m, "/", 2)[0]) == nil {
+			return m
+		}
+		return "[hidden]"
+	})
+}
+
+// text builds the TXT record for g: its reason, which ends with the gline
+// ID when there is one. TXT strings can't be longer than 255 bytes, so the
+// text is split as needed.
+func (c *DNSBLConfig) text(g *glineData) []string {
+	txt := g.Reason()
+	if c.RedactReason {
+		txt = redactIPs(txt)
+	}
+	var list []string
+	for len(txt) > 255 {
+		list = append(list, txt[:255])
+		txt = txt[255:]
+	}
+	return append(list, txt)
+}
+
+// dnsblGlines returns the active glines listing ip.
+func (s *serverData) dnsblGlines(ip net.IP) []*glineData {
+	active, _, err := s.CheckGline(ip.String(), false)
+	if err != nil {
+		return nil
+	}
+	list := make([]*glineData, 0, len(active))
+	for _, g := range active {
+		if g.user == "*" {
+			list = append(list, g)
+		}
+	}
+	return list
+}
+
+// dnsblAnswer builds the reply to a DNSBL query. Listed IPs get one A
+// record per distinct return code and one TXT record per gline.
+func (s *serverData) dnsblAnswer(req *dns.Msg) *dns.Msg {
+	cfg := &s.Config.DNSBL
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	if len(req.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return m
+	}
+	q := req.Question[0]
+	if strings.EqualFold(dns.Fqdn(q.Name), cfg.Zone) {
+		return m
+	}
+	ip := dnsblQueryIP(q.Name, cfg.Zone)
+	if ip == nil {
+		m.Rcode = dns.RcodeNameError
+		return m
+	}
+	glines := s.dnsblGlines(ip)
+	if len(glines) == 0 {
+		m.Rcode = dns.RcodeNameError
+		return m
+	}
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: cfg.ttl()}
+	seen := make(map[string]bool)
+	for _, g := range glines {
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeANY:
+			if code := cfg.code(g); !seen[code] {
+				seen[code] = true
+				hdr.Rrtype = dns.TypeA
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(code).To4()})
+			}
+		}
+		switch q.Qtype {
+		case dns.TypeTXT, dns.TypeANY:
+			hdr.Rrtype = dns.TypeTXT
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: cfg.text(g)})
+		}
+	}
+	return m
+}
+
+func (s *serverData) serveDNSBL(w dns.ResponseWriter, req *dns.Msg) {
+	if err := w.WriteMsg(s.dnsblAnswer(req)); err != nil {
+		debugLogf("serveDNSBL(): %s\n", err.Error())
+	}
+}
+
+// startDNSBL starts the DNSBL server on UDP and TCP.
+func (s *serverData) startDNSBL() {
+	cfg := &s.Config.DNSBL
+	if cfg.Zone == "" {
+		log.Fatal("DNSBL: zone is required")
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc(cfg.Zone, s.serveDNSBL)
+	for _, proto := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: cfg.Listen, Net: proto, Handler: mux}
+		go func() {
+			log.Printf("DNSBL: serving %s on %s/%s\n", cfg.Zone, cfg.Listen, srv.Net)
+			if err := srv.ListenAndServe(); err != nil {
+				log.Printf("DNSBL: %s/%s: %s\n", cfg.Listen, srv.Net, err.Error())
+			}
+		}()
+	}
+}
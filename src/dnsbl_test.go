@@ -0,0 +1,75 @@
+package ircglineapi
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDnsblQueryIP(t *testing.T) {
+	zone := "rbl.undernet.org."
+	cases := map[string]string{
+		"4.3.2.1.rbl.undernet.org.": "1.2.3.4",
+		"4.3.2.1.RBL.undernet.org":  "1.2.3.4",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.rbl.undernet.org.": "2001:db8::1",
+		"3.2.1.rbl.undernet.org.":     "<nil>",
+		"4.3.2.1.rbl.example.org.":    "<nil>",
+		"4.3.2.999.rbl.undernet.org.": "<nil>",
+	}
+	for name, want := range cases {
+		if got := dnsblQueryIP(name, zone).String(); got != want {
+			t.Errorf(`dnsblQueryIP(%s) = %s. Want %s`, name, got, want)
+		}
+	}
+}
+
+func TestRedactIPs(t *testing.T) {
+	in := "proxy on 10.1.2.3 and 2001:db8::/32, see 12:30:00"
+	want := "proxy on [hidden] and [hidden], see 12:30:00"
+	if got := redactIPs(in); got != want {
+		t.Errorf(`redactIPs(%q) = %q. Want %q`, in, got, want)
+	}
+}
+
+func TestDnsblAnswer(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network: "undernet",
+		Server:  "hidden.undernet.org",
+		Nick:    "GLD1",
+		DNSBL: DNSBLConfig{
+			Zone:         "rbl.undernet.org",
+			Categories:   []DNSBLCategory{{Name: "proxy", ReasonPattern: "proxy", Code: "127.0.0.3"}},
+			RedactReason: true,
+		},
+	})
+	compileDNSBL(&s.Config.DNSBL)
+	addTestGline(s, "*@10.0.0.5", 4102444800, 1000, "drone", true)
+	addTestGline(s, "*@10.0.3.0/24", 4102444800, 1000, "open proxy 10.0.3.5 - ID: D1-1", true)
+	addTestGline(s, "*@10.0.1.0/24", 4102444800, 1000, "expired", false)
+	_, botNet, _ := net.ParseCIDR("10.0.2.1/32")
+	active := true
+	s.AddOrUpdateGline(*botNet, "~bot", "~bot@10.0.2.1", "", 4102444800, 1000, "bot", &active, "")
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, qtype)
+		return s.dnsblAnswer(req)
+	}
+	for name, want := range map[string]string{"5.0.0.10.rbl.undernet.org.": "127.0.0.2", "7.3.0.10.rbl.undernet.org.": "127.0.0.3"} {
+		m := query(name, dns.TypeA)
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != want {
+			t.Errorf("A %s = rcode %d, %v. Want %s", name, m.Rcode, m.Answer, want)
+		}
+	}
+	m := query("7.3.0.10.rbl.undernet.org.", dns.TypeTXT)
+	if len(m.Answer) != 1 || strings.Join(m.Answer[0].(*dns.TXT).Txt, "") != "open proxy [hidden] - ID: D1-1" {
+		t.Errorf("TXT 10.0.3.7 = %v. Want the redacted reason", m.Answer)
+	}
+	for _, name := range []string{"1.1.0.10.rbl.undernet.org.", "1.2.0.10.rbl.undernet.org.", "1.2.3.rbl.undernet.org."} {
+		if m = query(name, dns.TypeA); m.Rcode != dns.RcodeNameError {
+			t.Errorf("A %s = rcode %d. Want NXDOMAIN", name, m.Rcode)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ircglineapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// dryRunKey is the context key telling handlers not to change anything.
+const dryRunKey = "dryrun"
+
+// dryRunResult is returned instead of the usual response by calls made in
+// dry-run mode.
+type dryRunResult = client.DryRunResult
+
+// dryRunRoutes are the state-changing endpoints that handle dry-run mode.
+// The others refuse dry-run calls, so that a new endpoint can't change
+// anything for a key meant to be harmless.
+var dryRunRoutes = map[string]bool{
+	"POST /api2/sendcommand/:network":        true,
+	"POST /api2/remgline/:network":           true,
+	"POST /api2/watchlists/:network/:name":   true,
+	"DELETE /api2/watchlists/:network/:name": true,
+	"POST /api2/approvals/:network/:id":      true,
+	"POST /api2/schedule/:network":           true,
+	"DELETE /api2/schedule/:network/:id":     true,
+	"POST /api3/remgline/:network":           true,
+	"POST /api3/sendcommand/:network":        true,
+}
+
+// isDryRun reports whether the call must only say what it would do.
+func isDryRun(c echo.Context) bool {
+	dry, _ := c.Get(dryRunKey).(bool)
+	return dry
+}
+
+// dryRunFlag sets dryRunKey for calls made with a dry-run key, while the
+// global DryRun setting is on, or with a dry_run parameter in the query
+// string or the body.
+func (a *ApiData) dryRunFlag(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, _ := c.Get(apiKeyNameKey).(string)
+		dry := a.Config.DryRun || containsFold(a.Config.DryRunKeys, key)
+		if !dry {
+			dry = dryRunParam(c)
+		}
+		c.Set(dryRunKey, dry)
+		method := c.Request().Method
+		if dry && method != http.MethodGet && method != http.MethodHead && !dryRunRoutes[method+" "+c.Path()] {
+			if strings.HasPrefix(c.Path(), "/api3/") {
+				return newApi3Error(http.StatusNotImplemented, api3ErrDryRunUnsupported, "dry-run mode is not supported by this endpoint").Send(c)
+			}
+			return c.JSON(http.StatusNotImplemented, "Dry run not supported by this endpoint")
+		}
+		return next(c)
+	}
+}
+
+func dryRunParam(c echo.Context) bool {
+	if values, ok := c.QueryParams()["dry_run"]; ok {
+		return parseDryRun(values[0])
+	}
+	req := c.Request()
+	if req.Body == nil || req.Method == "GET" {
+		return false
+	}
+	body, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		var in struct {
+			DryRun *bool `json:"dry_run"`
+		}
+		return json.Unmarshal(body, &in) == nil && in.DryRun != nil && *in.DryRun
+	}
+	form, err := url.ParseQuery(string(body))
+	if values, ok := form["dry_run"]; err == nil && ok {
+		return parseDryRun(values[0])
+	}
+	return false
+}
+
+// parseDryRun accepts a bare dry_run, or any value strconv.ParseBool does.
+func parseDryRun(v string) bool {
+	if v == "" {
+		return true
+	}
+	b, _ := strconv.ParseBool(v)
+	return b
+}
+
+func containsFold(list []string, str string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownGlines returns the glines with the given mask.
+func (s *serverData) knownGlines(mask string) []*glineData {
+	norm, ipNet, ok := normalizeMask(mask)
+	if !ok {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if g := s.findGline(*ipNet, norm); g != nil {
+		return []*glineData{g.Clone()}
+	}
+	return nil
+}
+
+// dryRunRemoval says what removing mask would do, message being what the
+// caller would announce.
+func (s *serverData) dryRunRemoval(mask, reason, message string) *dryRunResult {
+	r := &dryRunResult{DryRun: true, Lines: []string{}}
+	if rule := s.approvalRule(mask); rule != nil {
+		r.Approval = rule.Name
+	} else {
+		r.Lines = append(r.Lines, s.Remover.Lines(mask, reason)...)
+	}
+	r.Lines = append(r.Lines, s.announceLines(announceRemoval, message)...)
+	r.Glines = buildRetGlineDataList(s.knownGlines(mask), false)
+	return r
+}
+
+// dryRunCommand says what sending the raw line cmd would do. For GLINE
+// commands, the glines with the same mask are returned.
+func (s *serverData) dryRunCommand(cmd string) *dryRunResult {
+	r := &dryRunResult{DryRun: true, Lines: []string{cmd}}
+	var glines []*glineData
+	if w := strings.Fields(cmd); len(w) >= 2 && strings.EqualFold(w[0], "GLINE") {
+		glines = s.knownGlines(strings.TrimLeft(w[1], "+-!"))
+	}
+	r.Glines = buildRetGlineDataList(glines, false)
+	return r
+}
@@ -0,0 +1,148 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDryRun(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "drynet", Server: "hidden.undernet.org", Nick: "GLD1",
+		Channels: []string{"#ops"}, OperServNick: "euworld", OperServRemglineCmd: "removegline $glinemask",
+		Approvals: ApprovalConfig{Rules: []ApprovalRule{{Name: "auto", ReasonPattern: "^AUTO "}}}})
+	s.NetworkName = "drynet"
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.98.0.1", now+3600, now, "spam", true)
+	addTestGline(s, "*@10.98.0.2", now+3600, now, "AUTO [0] drone", true)
+	e := newApi(Configuration{ApiKey: "secret", ApiKeys: map[string]string{"staging": "test"}, DryRunKeys: []string{"staging"}})
+
+	var tests = []struct {
+		path, contentType, body, key string
+		code                         int
+		lines                        []string
+		glines                       int
+		approval                     string
+	}{
+		{"/api2/remgline/drynet?dry_run", "application/json",
+			`{"glinemask":"*@10.98.0.1","message":"lifted"}`, "secret", 200,
+			[]string{"PRIVMSG euworld :removegline *@10.98.0.1", "PRIVMSG #ops :lifted"}, 1, ""},
+		// Unknown glines are held, as the rules can't be checked.
+		{"/api2/remgline/drynet", "application/json",
+			`{"glinemask":"*@10.98.0.3","dry_run":true}`, "secret", 200,
+			[]string{}, 0, "unknown gline"},
+		{"/api3/remgline/drynet", "application/json", `{"mask":"*@10.98.0.2","dry_run":true}`, "secret", 200,
+			[]string{}, 1, "auto"},
+		// Masks are validated first, as they go in the IRC command.
+		{"/api2/remgline/drynet?dry_run", "application/json", `{"glinemask":"a\r\nQUIT"}`, "secret", 400, nil, 0, ""},
+		{"/api2/remgline/drynet", "application/json", `{"glinemask":"*@10.98.0.1 x"}`, "secret", 400, nil, 0, ""},
+		{"/api2/sendcommand/drynet", "application/json",
+			`{"command":"GLINE -*@10.98.0.1"}`, "test", 200,
+			[]string{"GLINE -*@10.98.0.1"}, 1, ""},
+		// Without dry-run, the calls fail as the bot isn't connected. The
+		// query string takes precedence over the body.
+		{"/api2/sendcommand/drynet?dry_run=false", "application/x-www-form-urlencoded",
+			"command=" + url.QueryEscape("GLINE -*@10.98.0.1") + "&dry_run=true", "secret", 503, nil, 0, ""},
+		{"/api3/remgline/drynet", "application/json", `{"mask":"*@10.98.0.1"}`, "secret", 503, nil, 0, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		r.Header.Set("Authorization", "Bearer "+tt.key)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf(`POST %s %s = %d %s. Want %d`, tt.path, tt.body, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var res dryRunResult
+		body := w.Body.Bytes()
+		if strings.HasPrefix(tt.path, "/api3/") {
+			var env struct {
+				Data json.RawMessage `json:"data"`
+			}
+			json.Unmarshal(body, &env)
+			body = env.Data
+		}
+		if err := json.Unmarshal(body, &res); err != nil || !res.DryRun {
+			t.Errorf(`POST %s = %s. Want a dry-run result`, tt.path, w.Body.String())
+			continue
+		}
+		if strings.Join(res.Lines, "|") != strings.Join(tt.lines, "|") || len(res.Glines) != tt.glines || res.Approval != tt.approval {
+			t.Errorf(`POST %s %s = %+v. Want lines %q, %d glines, approval %q`, tt.path, tt.body, res, tt.lines, tt.glines, tt.approval)
+		}
+	}
+	if len(s.Approvals.List()) != 0 {
+		t.Errorf(`a dry run queued a removal for approval`)
+	}
+}
+
+func TestDryRunWrites(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "drywnet", Server: "hidden.undernet.org", Nick: "GLD2"})
+	s.NetworkName = "drywnet"
+	s.Watchlists.Add("uni", "198.51.100.0/24")
+	s.Schedule.Add(&scheduledJob{Action: scheduleRemove, Mask: "*@10.98.1.1", AtTS: time.Now().Add(time.Hour).Unix()})
+	id := s.Schedule.List()[0].ID
+	e := newApi(Configuration{ApiKey: "secret"})
+	// A state-changing endpoint that doesn't handle dry-run mode.
+	e.POST("/api2/dryruntest/:network", func(c echo.Context) error {
+		t.Errorf(`a dry-run call reached a handler without dry-run support`)
+		return c.JSON(http.StatusOK, "done")
+	})
+
+	var tests = []struct {
+		method, path, body string
+		code               int
+		watchlist          []string
+		job                bool
+	}{
+		{"POST", "/api2/watchlists/drywnet/uni", `{"cidrs":["203.0.113.0/24"]}`, 200, []string{"198.51.100.0/24", "203.0.113.0/24"}, false},
+		{"POST", "/api2/watchlists/drywnet/uni", `{"cidrs":["not a cidr"]}`, 400, nil, false},
+		{"DELETE", "/api2/watchlists/drywnet/uni?cidr=198.51.100.0/24", "", 200, nil, false},
+		{"DELETE", "/api2/watchlists/drywnet/uni?cidr=203.0.113.0/24", "", 404, nil, false},
+		{"DELETE", "/api2/schedule/drywnet/" + strconv.Itoa(id), "", 200, nil, true},
+		{"DELETE", "/api2/schedule/drywnet/999", "", 404, nil, false},
+		{"POST", "/api2/dryruntest/drywnet", `{}`, 501, nil, false},
+	}
+	for _, tt := range tests {
+		path := tt.path + "?dry_run"
+		if strings.Contains(tt.path, "?") {
+			path = tt.path + "&dry_run"
+		}
+		r := httptest.NewRequest(tt.method, path, strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf(`%s %s = %d %s. Want %d`, tt.method, tt.path, w.Code, w.Body.String(), tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var res dryRunResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || !res.DryRun {
+			t.Errorf(`%s %s = %s. Want a dry-run result`, tt.method, tt.path, w.Body.String())
+			continue
+		}
+		if strings.Join(res.Watchlist, "|") != strings.Join(tt.watchlist, "|") || (res.Job != nil) != tt.job {
+			t.Errorf(`%s %s = %+v. Want watchlist %q, job %v`, tt.method, tt.path, res, tt.watchlist, tt.job)
+		}
+	}
+	if list := s.Watchlists.Lists()["uni"]; len(list) != 1 || list[0] != "198.51.100.0/24" {
+		t.Errorf(`watchlist after dry runs = %q. Want it unchanged`, list)
+	}
+	if len(s.Schedule.List()) != 1 {
+		t.Errorf(`a dry run cancelled the scheduled job`)
+	}
+}
@@ -0,0 +1,149 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Event is one entry of the event stream served at /api2/events/:network.
+type Event struct {
+	Type    string      `json:"type"`
+	Network string      `json:"network"`
+	TS      int64       `json:"ts"`
+	Data    interface{} `json:"data"`
+}
+
+// eventBus fans events out to subscribers. Publishing never blocks: a
+// subscriber that falls too far behind misses events.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every published event, and a
+// function to call once done with it.
+func (b *eventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *eventBus) Publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// publish sends an event of type typ on s's event stream.
+func (s *serverData) publish(typ string, data interface{}) {
+	s.Events.Publish(Event{
+		Type:    typ,
+		Network: s.Config.Network,
+		TS:      time.Now().Unix(),
+		Data:    data,
+	})
+}
+
+// eventGline is how glines are described on the event stream.
+type eventGline struct {
+	Mask     string `json:"mask"`
+	Active   bool   `json:"active"`
+	ExpireTS int64  `json:"expirets"`
+	Reason   string `json:"reason"`
+	ID       string `json:"id,omitempty"`
+	Setter   string `json:"setter,omitempty"`
+}
+
+func newEventGline(g *glineData) *eventGline {
+	return &eventGline{
+		Mask:     g.Mask(),
+		Active:   g.IsGlineActive(),
+		ExpireTS: g.ExpireTS(),
+		Reason:   g.Reason(),
+		ID:       g.ID(),
+		Setter:   g.Setter(),
+	}
+}
+
+// publishGlineChange is a gline observer putting live changes on the
+// event stream as "gline.add", "gline.modify" and "gline.expire" events.
+func (s *serverData) publishGlineChange(c *glineChange) {
+	if !c.Live() {
+		return
+	}
+	s.publish("gline."+c.Kind, newEventGline(c.Gline))
+}
+
+type api_events_struct struct {
+	Network string `param:"network"`
+	Types   string `query:"types"`
+}
+
+// eventsApi streams events as server-sent events until the client goes
+// away. ?types= takes a comma-separated list of event types to receive.
+func (a *ApiData) eventsApi(c echo.Context) error {
+	var in api_events_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	wanted := make(map[string]bool)
+	for _, t := range strings.Split(in.Types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			wanted[t] = true
+		}
+	}
+	events, unsubscribe := s.Events.Subscribe()
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			w.Flush()
+		case ev := <-events:
+			if len(wanted) > 0 && !wanted[ev.Type] {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			w.Flush()
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package ircglineapi
+
+import (
+	"container/heap"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExpiryConfig controls what happens when a gline reaches its expiration
+// time. An "expire" change is always put on the event stream and the
+// change feed.
+type ExpiryConfig struct {
+	// Announce expirations in the main channel.
+	Announce bool
+}
+
+// expiryItem is a gline scheduled to expire at ts.
+type expiryItem struct {
+	ts    int64
+	ipNet net.IPNet
+	mask  string
+}
+
+type expiryHeap []*expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].ts < h[j].ts }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(*expiryItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// expiryQueue orders active glines by expiration time. Items aren't removed
+// when a gline is modified: a new one is pushed, and the outdated one is
+// recognized and skipped when it comes due.
+type expiryQueue struct {
+	mu        sync.Mutex
+	items     expiryHeap
+	scheduled map[string]int64 // latest ts pushed, by lowercased mask
+	wake      chan struct{}
+	expired   atomic.Uint64
+}
+
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{
+		scheduled: make(map[string]int64),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Schedule is a gline observer queueing active glines for expiration.
+func (q *expiryQueue) Schedule(c *glineChange) {
+	g := c.Gline
+	if c.Kind == glineExpired || !g.IsGlineActive() {
+		return
+	}
+	key := strings.ToLower(g.mask)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.scheduled[key] == g.expireTS {
+		return
+	}
+	q.scheduled[key] = g.expireTS
+	heap.Push(&q.items, &expiryItem{ts: g.expireTS, ipNet: g.ipNet, mask: g.mask})
+	if q.items[0].ts == g.expireTS {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// due pops the items whose time has come.
+func (q *expiryQueue) due(now int64) []*expiryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var list []*expiryItem
+	for len(q.items) > 0 && q.items[0].ts <= now {
+		item := heap.Pop(&q.items).(*expiryItem)
+		key := strings.ToLower(item.mask)
+		if q.scheduled[key] == item.ts {
+			delete(q.scheduled, key)
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// next returns when the earliest item is due, if there is one.
+func (q *expiryQueue) next() (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return 0, false
+	}
+	return q.items[0].ts, true
+}
+
+// Expired returns the number of glines that expired since startup.
+func (q *expiryQueue) Expired() uint64 {
+	return q.expired.Load()
+}
+
+// findGline returns the live gline with the given mask on ipNet, or nil.
+// The caller must hold s.mu.
+func (s *serverData) findGline(ipNet net.IPNet, mask string) *glineData {
+	entries, err := s.Cranger.CoveringOrCoveredNetworks(ipNet)
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		gd, ok := e.(*glinesData)
+		if !ok {
+			continue
+		}
+		for _, g := range gd.Glines {
+			if sameMask(g.mask, mask) {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// expireDue emits an "expire" change for every gline whose expiration time
+// has passed at time now, unless it was modified or removed meanwhile.
+func (s *serverData) expireDue(now int64) {
+	for _, item := range s.Expiry.due(now) {
+		s.mu.Lock()
+		g := s.findGline(item.ipNet, item.mask)
+		if g == nil || !g.active || g.expireTS != item.ts {
+			s.mu.Unlock()
+			continue
+		}
+		change := newGlineChange(glineExpired, g, true, "", "")
+		s.Changes.Append(change)
+		s.mu.Unlock()
+		s.Expiry.expired.Add(1)
+		debugLogf("serverData.expireDue(): %s expired\n", item.mask)
+		s.notifyGlineChange(change)
+		if s.Config.Expiry.Announce {
+			s.announce(announceExpiry, fmt.Sprintf("Gline expired: %s: %s", change.Gline.Mask(), change.Gline.Reason()))
+		}
+	}
+}
+
+// expiryLoop runs expireDue whenever the next gline comes due.
+func (s *serverData) expiryLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		wait := time.Hour
+		if ts, ok := s.Expiry.next(); ok {
+			wait = time.Until(time.Unix(ts, 0))
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+			s.expireDue(time.Now().Unix())
+		case <-s.Expiry.wake:
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ircglineapi
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExpireDue(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "expirynet", Server: "hidden.undernet.org", Nick: "GLE1"})
+	events, unsubscribe := s.Events.Subscribe()
+	defer unsubscribe()
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.90.0.1", now+100, now, "extended later", true)
+	addTestGline(s, "*@10.91.0.1", now+100, now, "deactivated", true)
+	addTestGline(s, "*@10.92.0.1", now+200, now, "expires", true)
+	// Relisting must not schedule it twice.
+	addTestGline(s, "*@10.92.0.1", now+200, now, "expires", true)
+
+	addTestGline(s, "*@10.90.0.1", now+300, now, "extended later", true)
+	inactive := false
+	_, ipNet, _ := net.ParseCIDR("10.91.0.1/32")
+	s.AddOrUpdateGline(*ipNet, "*", "*@10.91.0.1", "", 0, 0, "", &inactive, "")
+	cursor := s.Changes.Cursor()
+
+	var tests = []struct {
+		now  int64
+		want []string
+	}{
+		{now + 50, nil},
+		{now + 150, nil},
+		{now + 250, []string{"*@10.92.0.1"}},
+		{now + 350, []string{"*@10.90.0.1"}},
+		{now + 1000, nil},
+	}
+	for _, tt := range tests {
+		s.expireDue(tt.now)
+		var got []string
+		for len(events) > 0 {
+			ev := <-events
+			if ev.Type != "gline.expire" {
+				t.Errorf(`expireDue(now+%d) published %s. Want gline.expire`, tt.now-now, ev.Type)
+				continue
+			}
+			got = append(got, ev.Data.(*eventGline).Mask)
+		}
+		if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+			t.Errorf(`expireDue(now+%d) expired %q. Want %q`, tt.now-now, got, tt.want)
+		}
+	}
+	if got := s.Expiry.Expired(); got != 2 {
+		t.Errorf(`Expired() = %d. Want 2`, got)
+	}
+	entries, _, _ := s.Changes.Since(cursor, 10)
+	if len(entries) != 2 || entries[0].Kind != glineExpired || entries[1].Kind != glineExpired {
+		t.Errorf(`change feed got %d entries. Want 2 expire changes`, len(entries))
+	}
+}
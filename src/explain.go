@@ -0,0 +1,206 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hiddn/irc-glines-api/client"
+)
+
+// ExplanationConfig is a catalogue of plain-language explanations for the
+// kinds of glines users may run into.
+type ExplanationConfig struct {
+	// Language used when none of the requested ones is available.
+	// Defaults to "en".
+	DefaultLanguage string
+	// The first entry matching a gline explains it.
+	Entries []ExplanationEntry
+}
+
+// ExplanationEntry matches glines by the policy code parsed from their
+// reason (see ReasonParserConfig), or by a reason regex.
+type ExplanationEntry struct {
+	Category      string
+	Policies      []string
+	ReasonPattern string
+	SelfRemoval   bool
+	// Texts by language tag, e.g. "en" or "fr".
+	Texts map[string]ExplanationText
+}
+
+type ExplanationText struct {
+	Title       string
+	Explanation string
+	Removal     string
+	// Shown by the bot after the gline. Defaults to Title.
+	Short string
+}
+
+// Explanation is returned with each gline by the lookup endpoints.
+type Explanation = client.Explanation
+
+type explanationCatalog struct {
+	defaultLang string
+	entries     []ExplanationEntry
+	reasons     []*regexp.Regexp
+}
+
+func newExplanationCatalog(cfg ExplanationConfig) *explanationCatalog {
+	c := &explanationCatalog{defaultLang: strings.ToLower(cfg.DefaultLanguage)}
+	if c.defaultLang == "" {
+		c.defaultLang = "en"
+	}
+	for _, e := range cfg.Entries {
+		texts := make(map[string]ExplanationText, len(e.Texts))
+		for lang, t := range e.Texts {
+			texts[strings.ToLower(lang)] = t
+		}
+		e.Texts = texts
+		c.entries = append(c.entries, e)
+		var re *regexp.Regexp
+		if e.ReasonPattern != "" {
+			var err error
+			if re, err = regexp.Compile("(?i)" + e.ReasonPattern); err != nil {
+				log.Fatalf("Invalid reason pattern for explanation %s: %s", e.Category, err.Error())
+			}
+		}
+		c.reasons = append(c.reasons, re)
+	}
+	return c
+}
+
+// match returns the entry explaining g, or nil.
+func (c *explanationCatalog) match(g *glineData) *ExplanationEntry {
+	policy := g.Info().Policy
+	for i := range c.entries {
+		e := &c.entries[i]
+		for _, p := range e.Policies {
+			if policy != "" && strings.EqualFold(p, policy) {
+				return e
+			}
+		}
+		if c.reasons[i] != nil && c.reasons[i].MatchString(g.Reason()) {
+			return e
+		}
+	}
+	return nil
+}
+
+// text picks the best text of e for langs, the languages wanted in order
+// of preference. "fr-CA" falls back to "fr", then to the default language
+// and finally to any language available.
+func (c *explanationCatalog) text(e *ExplanationEntry, langs []string) (string, ExplanationText, bool) {
+	for _, l := range append(append([]string{}, langs...), c.defaultLang) {
+		l = strings.ToLower(l)
+		if t, ok := e.Texts[l]; ok {
+			return l, t, true
+		}
+		if i := strings.Index(l, "-"); i != -1 {
+			if t, ok := e.Texts[l[:i]]; ok {
+				return l[:i], t, true
+			}
+		}
+	}
+	keys := make([]string, 0, len(e.Texts))
+	for k := range e.Texts {
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return "", ExplanationText{}, false
+	}
+	sort.Strings(keys)
+	return keys[0], e.Texts[keys[0]], true
+}
+
+// Explain returns the explanation of g in the best of langs, or nil if the
+// catalogue doesn't cover g.
+func (c *explanationCatalog) Explain(g *glineData, langs []string) *Explanation {
+	e := c.match(g)
+	if e == nil {
+		return nil
+	}
+	lang, t, ok := c.text(e, langs)
+	if !ok {
+		return nil
+	}
+	return &Explanation{
+		Category:    e.Category,
+		Language:    lang,
+		Title:       t.Title,
+		Explanation: t.Explanation,
+		Removal:     t.Removal,
+		SelfRemoval: e.SelfRemoval,
+	}
+}
+
+// Short returns the short form of g's explanation in the default language,
+// or "".
+func (c *explanationCatalog) Short(g *glineData) string {
+	e := c.match(g)
+	if e == nil {
+		return ""
+	}
+	_, t, ok := c.text(e, nil)
+	if !ok {
+		return ""
+	}
+	short := t.Short
+	if short == "" {
+		short = t.Title
+	}
+	if e.SelfRemoval {
+		short += ", self-removal possible"
+	}
+	return short
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language
+// header, most preferred first.
+func parseAcceptLanguage(header string) []string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{lang, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	langs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		langs = append(langs, t.lang)
+	}
+	return langs
+}
+
+// formatGlineLineDetailed is formatGlineLine followed by the ASN and
+// country of the gline and its short explanation, when known.
+func (s *serverData) formatGlineLineDetailed(g *glineData) string {
+	line := formatGlineLine(g)
+	if geo := s.geoInfoOf(g); !geo.Empty() {
+		line = fmt.Sprintf("%s (%s)", line, geo)
+	}
+	if short := s.Explanations.Short(g); short != "" {
+		line = fmt.Sprintf("%s [%s]", line, short)
+	}
+	return line
+}
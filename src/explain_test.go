@@ -0,0 +1,97 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+var testExplanations = ExplanationConfig{
+	Entries: []ExplanationEntry{
+		{
+			Category:    "drone",
+			Policies:    []string{"P540"},
+			SelfRemoval: true,
+			Texts: map[string]ExplanationText{
+				"en": {Title: "Infected computer", Explanation: "Your computer is part of a botnet.", Removal: "Clean it, then email abuse@undernet.org.", Short: "drone"},
+				"FR": {Title: "Ordinateur infecté", Explanation: "Votre ordinateur fait partie d'un botnet.", Removal: "Nettoyez-le puis écrivez à abuse@undernet.org."},
+			},
+		},
+		{
+			Category:      "proxy",
+			ReasonPattern: `open proxy`,
+			Texts: map[string]ExplanationText{
+				"en": {Title: "Open proxy"},
+			},
+		},
+	},
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                              "",
+		"fr-CA,fr;q=0.9,en;q=0.8":       "fr-CA fr en",
+		"en;q=0.5, de, *;q=0.1, es;q=0": "de en",
+	}
+	for header, want := range cases {
+		if got := strings.Join(parseAcceptLanguage(header), " "); got != want {
+			t.Errorf(`parseAcceptLanguage(%q) = %q. Want %q`, header, got, want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "undernet", Server: "hidden.undernet.org", Nick: "GLX1", Explanations: testExplanations})
+	addTestGline(s, "*@10.0.0.1", 4102444800, 1000, "AUTO [0] (10.0.0.1) drone (P540)", true)
+	addTestGline(s, "*@10.0.0.2", 4102444800, 1000, "open proxy", true)
+	addTestGline(s, "*@10.0.0.3", 4102444800, 1000, "spam", true)
+	get := func(ip string) *glineData {
+		active, _, _ := s.CheckGline(ip, false)
+		return active[0]
+	}
+	cases := []struct {
+		ip    string
+		langs []string
+		want  string // language and title
+	}{
+		{"10.0.0.1", []string{"fr-CA", "en"}, "fr Ordinateur infecté"},
+		{"10.0.0.1", []string{"de"}, "en Infected computer"},
+		{"10.0.0.2", []string{"fr"}, "en Open proxy"},
+	}
+	for _, c := range cases {
+		e := s.Explanations.Explain(get(c.ip), c.langs)
+		if e == nil || e.Language+" "+e.Title != c.want {
+			t.Errorf(`Explain(%s, %v) = %+v. Want %s`, c.ip, c.langs, e, c.want)
+		}
+	}
+	if e := s.Explanations.Explain(get("10.0.0.3"), nil); e != nil {
+		t.Errorf(`Explain(10.0.0.3) = %+v. Want nil`, e)
+	}
+	if got := s.formatGlineLineDetailed(get("10.0.0.1")); !strings.HasSuffix(got, "[drone, self-removal possible]") {
+		t.Errorf(`formatGlineLineDetailed(10.0.0.1) = %q`, got)
+	}
+}
+
+func TestGlineLookupApiExplanation(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "explainnet", Server: "hidden.undernet.org", Nick: "GLX2", Explanations: testExplanations})
+	s.NetworkName = "explainnet"
+	addTestGline(s, "*@10.0.0.1", 4102444800, 1000, "AUTO [0] (10.0.0.1) drone (P540)", true)
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/glinelookup/:network/:ip", a.glineLookupApi)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api2/glinelookup/explainnet/10.0.0.1", nil)
+	r.Header.Set("Accept-Language", "fr-FR,fr;q=0.9")
+	e.ServeHTTP(w, r)
+	var list []RetGlineData
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Explanation == nil {
+		t.Fatalf("glinelookup = %d %s. Want one explained gline", w.Code, w.Body.String())
+	}
+	if x := list[0].Explanation; x.Category != "drone" || x.Language != "fr" || !x.SelfRemoval {
+		t.Errorf("explanation = %+v. Want the French drone explanation", x)
+	}
+}
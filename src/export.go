@@ -0,0 +1,216 @@
+package ircglineapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// glineExporter writes glines in one export format.
+type glineExporter interface {
+	Begin(w io.Writer) error
+	Write(w io.Writer, g *glineData) error
+	End(w io.Writer) error
+}
+
+// exportFormat describes an export format. IP lists (cidr*, rbldnsd) only
+// hold glines on any user, i.e. "*@...", and only active ones unless the
+// active filter says otherwise.
+type exportFormat struct {
+	ContentType string
+	IPList      bool
+	New         func() glineExporter
+}
+
+var exportFormats = map[string]exportFormat{
+	"jsonl":   {"application/x-ndjson", false, func() glineExporter { return &jsonlExporter{} }},
+	"csv":     {"text/csv; charset=utf-8", false, func() glineExporter { return &csvExporter{} }},
+	"cidr":    {echo.MIMETextPlainCharsetUTF8, true, func() glineExporter { return &cidrExporter{} }},
+	"cidr4":   {echo.MIMETextPlainCharsetUTF8, true, func() glineExporter { return &cidrExporter{bits: 32} }},
+	"cidr6":   {echo.MIMETextPlainCharsetUTF8, true, func() glineExporter { return &cidrExporter{bits: 128} }},
+	"rbldnsd": {echo.MIMETextPlainCharsetUTF8, true, func() glineExporter { return &rbldnsdExporter{} }},
+}
+
+func exportFormatNames() []string {
+	return []string{"jsonl", "csv", "cidr", "cidr4", "cidr6", "rbldnsd"}
+}
+
+// exportGlines writes every gline matching f to w, straight from the trie.
+func (s *serverData) exportGlines(w io.Writer, format exportFormat, f *glineFilter) error {
+	ex := format.New()
+	if err := ex.Begin(w); err != nil {
+		return err
+	}
+	var err error
+	s.forEachGline(func(g *glineData) bool {
+		if format.IPList && g.user != "*" {
+			return true
+		}
+		if !f.Match(g) {
+			return true
+		}
+		err = ex.Write(w, g)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return ex.End(w)
+}
+
+type jsonlExporter struct{}
+
+func (e *jsonlExporter) Begin(w io.Writer) error { return nil }
+func (e *jsonlExporter) End(w io.Writer) error   { return nil }
+
+func (e *jsonlExporter) Write(w io.Writer, g *glineData) error {
+	data, err := json.Marshal(buildRetGlineDataList([]*glineData{g}, false)[0])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Begin(w io.Writer) error {
+	e.w = csv.NewWriter(w)
+	return e.w.Write([]string{"mask", "active", "expirets", "lastmodts", "setter", "id", "auto", "level", "ip", "policy", "email", "url", "reason"})
+}
+
+func (e *csvExporter) Write(w io.Writer, g *glineData) error {
+	info := g.Info()
+	level := ""
+	if info.Level != nil {
+		level = strconv.Itoa(*info.Level)
+	}
+	e.w.Write([]string{
+		g.Mask(),
+		strconv.FormatBool(g.IsGlineActive()),
+		strconv.FormatInt(g.ExpireTS(), 10),
+		strconv.FormatInt(g.LastModTS(), 10),
+		g.Setter(),
+		g.ID(),
+		strconv.FormatBool(info.Auto),
+		level,
+		info.IP,
+		info.Policy,
+		info.Email,
+		info.URL,
+		g.Reason(),
+	})
+	return e.w.Error()
+}
+
+func (e *csvExporter) End(w io.Writer) error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// cidrExporter writes one CIDR per line. bits is 32 or 128 to only list
+// IPv4 or IPv6 networks, 0 for both.
+type cidrExporter struct {
+	bits int
+	last string
+}
+
+func (e *cidrExporter) Begin(w io.Writer) error { return nil }
+func (e *cidrExporter) End(w io.Writer) error   { return nil }
+
+func (e *cidrExporter) Write(w io.Writer, g *glineData) error {
+	if _, bits := g.ipNet.Mask.Size(); e.bits != 0 && bits != e.bits {
+		return nil
+	}
+	// Glines on the same network come one after the other.
+	cidr := g.ipNet.String()
+	if cidr == e.last {
+		return nil
+	}
+	e.last = cidr
+	_, err := fmt.Fprintln(w, cidr)
+	return err
+}
+
+// rbldnsdExporter writes an rbldnsd "combined" dataset, with an ip4trie
+// and an ip6trie subdataset. The TXT record is the gline reason.
+type rbldnsdExporter struct {
+	cidrExporter
+	v6 bool
+}
+
+func (e *rbldnsdExporter) Begin(w io.Writer) error {
+	_, err := fmt.Fprint(w, "# glines exported by irc-glines-api\n$DATASET ip4trie @\n:127.0.0.2:Glined\n")
+	return err
+}
+
+func (e *rbldnsdExporter) Write(w io.Writer, g *glineData) error {
+	cidr := g.ipNet.String()
+	if cidr == e.last {
+		return nil
+	}
+	e.last = cidr
+	if _, bits := g.ipNet.Mask.Size(); bits == 128 && !e.v6 {
+		e.v6 = true
+		if _, err := fmt.Fprint(w, "$DATASET ip6trie @\n:127.0.0.2:Glined\n"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s :127.0.0.2:%s\n", cidr, rbldnsdText(g.Reason()))
+	return err
+}
+
+// rbldnsdText makes a reason safe for an rbldnsd TXT value: "$" would be
+// substituted with the queried IP.
+func rbldnsdText(reason string) string {
+	return strings.NewReplacer("$", "", "\r", " ", "\n", " ").Replace(reason)
+}
+
+type api_export_struct struct {
+	Network string `param:"network"`
+	Format  string `query:"format"`
+	Filter  glineFilterParams
+}
+
+// exportApi streams the gline set in the requested format, jsonl by
+// default. It takes the same filters as searchApi.
+func (a *ApiData) exportApi(c echo.Context) error {
+	var in api_export_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if in.Format == "" {
+		in.Format = "jsonl"
+	}
+	format, ok := exportFormats[in.Format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, "Unknown format. Use one of: "+strings.Join(exportFormatNames(), ", "))
+	}
+	if format.IPList && in.Filter.Active == "" {
+		in.Filter.Active = "true"
+	}
+	f, err := newGlineFilter(in.Filter)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, format.ContentType)
+	w.Header().Set(changesCursorHeader, strconv.FormatUint(s.Changes.Cursor(), 10))
+	w.WriteHeader(http.StatusOK)
+	if err := s.exportGlines(w, format, f); err != nil {
+		debugLogf("exportApi(): %s\n", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package ircglineapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestExportFormats(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network: "exportnet",
+		Server:  "hidden.undernet.org",
+		Nick:    "GLE1",
+	})
+	s.NetworkName = "exportnet"
+	addTestGline(s, "*@10.0.0.0/24", 4102444800, 1000, "drones - ID: D1-1", true)
+	addTestGline(s, "~bot@10.0.0.0/24", 4102444800, 1000, "bots", true)
+	addTestGline(s, "*@10.1.0.1", 4102444800, 1000, "costs $5", true)
+	addTestGline(s, "*@10.2.0.1", 4102444800, 1000, "removed", false)
+	addTestGline(s, "*@2001:db8::/32", 4102444800, 1000, "v6 drones", true)
+
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/export/:network", a.exportApi)
+	cases := []struct {
+		query string
+		want  string
+	}{
+		{"format=cidr", "10.0.0.0/24\n10.1.0.1/32\n2001:db8::/32\n"},
+		{"format=cidr4&active=false", "10.2.0.1/32\n"},
+		{"format=cidr6", "2001:db8::/32\n"},
+		{"format=cidr&reason=DRONES", "10.0.0.0/24\n2001:db8::/32\n"},
+		{"format=rbldnsd&reason=costs", "# glines exported by irc-glines-api\n$DATASET ip4trie @\n:127.0.0.2:Glined\n10.1.0.1/32 :127.0.0.2:costs 5\n"},
+		{"format=csv&reason=bots", "mask,active,expirets,lastmodts,setter,id,auto,level,ip,policy,email,url,reason\n~bot@10.0.0.0/24,true,4102444800,1000,,,false,,,,,,bots\n"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api2/export/exportnet?"+c.query, nil)
+		e.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != c.want {
+			t.Errorf("export?%s = %d %q. Want %q", c.query, w.Code, w.Body.String(), c.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api2/export/exportnet?reason=v6|removed", nil)
+	e.ServeHTTP(w, r)
+	var got []string
+	dec := json.NewDecoder(w.Body)
+	for dec.More() {
+		var g RetGlineData
+		if err := dec.Decode(&g); err != nil {
+			t.Fatalf("export?reason=v6|removed returned invalid JSON lines: %s", err.Error())
+		}
+		got = append(got, g.Mask)
+	}
+	if strings.Join(got, " ") != "*@10.2.0.1 *@2001:db8::/32" {
+		t.Errorf("export?reason=v6|removed = %v. Want [*@10.2.0.1 *@2001:db8::/32]", got)
+	}
+}
+
+func TestExportRejectsBadInput(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "exportnet2", Server: "hidden.undernet.org", Nick: "GLE2"})
+	s.NetworkName = "exportnet2"
+	var buf bytes.Buffer
+	for _, query := range []string{"format=xml", "active=maybe", "reason=("} {
+		e := echo.New()
+		a := &ApiData{EchoInstance: e}
+		e.GET("/api2/export/:network", a.exportApi)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api2/export/exportnet2?"+query, nil)
+		e.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			buf.WriteString(query + " ")
+		}
+	}
+	if buf.Len() > 0 {
+		t.Errorf("export accepted invalid queries: %s", strings.TrimSpace(buf.String()))
+	}
+}
@@ -2,7 +2,9 @@ package ircglineapi
 
 import (
 	"net"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Returns ip/32 if ipv4 address provided without cidr
@@ -22,6 +24,42 @@ func AddCidrToIP(ip string) string {
 	return ip
 }
 
+// normalizeMask returns user@host with host in its canonical form, without
+// the prefix length for a single address: *@1.2.3.4/32 and *@1.2.3.4 are
+// the same gline. ok is false if host isn't an IP or a CIDR.
+func normalizeMask(mask string) (norm string, ipNet *net.IPNet, ok bool) {
+	user, host, found := strings.Cut(mask, "@")
+	if !found {
+		return "", nil, false
+	}
+	_, ipNet, err := net.ParseCIDR(AddCidrToIP(host))
+	if err != nil {
+		return "", nil, false
+	}
+	host = ipNet.String()
+	if ones, bits := ipNet.Mask.Size(); ones == bits {
+		host = ipNet.IP.String()
+	}
+	return strings.ToLower(user) + "@" + host, ipNet, true
+}
+
+// validMask reports whether mask is user@host, on a single line and without
+// spaces, so that it can be put in an IRC command.
+func validMask(mask string) bool {
+	user, host, ok := strings.Cut(mask, "@")
+	return ok && user != "" && host != "" && !strings.ContainsAny(mask, " \r\n")
+}
+
+// sameMask reports whether two gline masks are the same, once normalized.
+func sameMask(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	na, _, ok := normalizeMask(a)
+	nb, _, ok2 := normalizeMask(b)
+	return ok && ok2 && na == nb
+}
+
 func StripCidrFromIP(ip string) string {
 	s := strings.Split(ip, "/")
 	if len(s) > 1 {
@@ -38,3 +76,55 @@ func GetFileNameFromFullPath(f string) string {
 	s := strings.Split(f, "/")
 	return s[len(f)-1]
 }
+
+// MatchMask reports whether s matches the IRC-style glob pattern, where
+// '*' matches any run of characters and '?' matches exactly one. The
+// comparison is case-insensitive, as for nick!user@host masks.
+func MatchMask(pattern, s string) bool {
+	p := []rune(strings.ToLower(pattern))
+	str := []rune(strings.ToLower(s))
+	pi, si := 0, 0
+	star, match := -1, 0
+	for si < len(str) {
+		if pi < len(p) && (p[pi] == '?' || p[pi] == str[si]) {
+			pi++
+			si++
+		} else if pi < len(p) && p[pi] == '*' {
+			star = pi
+			match = si
+			pi++
+		} else if star != -1 {
+			pi = star + 1
+			match++
+			si = match
+		} else {
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// ParseDuration is time.ParseDuration with support for days ("3d") and
+// weeks ("2w"), which are what people type on IRC.
+func ParseDuration(str string) (time.Duration, error) {
+	if n := len(str); n > 1 {
+		unit := time.Duration(0)
+		switch str[n-1] {
+		case 'd', 'D':
+			unit = 24 * time.Hour
+		case 'w', 'W':
+			unit = 7 * 24 * time.Hour
+		}
+		if unit != 0 {
+			v, err := strconv.ParseFloat(str[:n-1], 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(str)
+}
@@ -0,0 +1,318 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPConfig enables ASN and country enrichment from local .mmdb files.
+// Both MaxMind (GeoLite2-ASN, GeoLite2-Country, ...) and IPinfo
+// (country_asn, ...) layouts are understood. The files are reloaded when
+// they change on disk.
+type GeoIPConfig struct {
+	Files []string
+	// How often the files are checked for changes. Defaults to 60.
+	CheckIntervalSeconds int
+}
+
+func (c GeoIPConfig) checkInterval() time.Duration {
+	if c.CheckIntervalSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.CheckIntervalSeconds) * time.Second
+}
+
+// geoInfo is what the databases know about an IP.
+type geoInfo struct {
+	ASN     uint64
+	ASOrg   string
+	Country string
+}
+
+func (g geoInfo) Empty() bool {
+	return g.ASN == 0 && g.ASOrg == "" && g.Country == ""
+}
+
+// String formats g for the bot, e.g. "AS15169 Google LLC, US".
+func (g geoInfo) String() string {
+	var parts []string
+	if g.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", g.ASN, g.ASOrg)))
+	} else if g.ASOrg != "" {
+		parts = append(parts, g.ASOrg)
+	}
+	if g.Country != "" {
+		parts = append(parts, g.Country)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// geoReader is implemented by *maxminddb.Reader.
+type geoReader interface {
+	Lookup(ip net.IP, result any) error
+	Close() error
+}
+
+type geoFile struct {
+	path    string
+	modTime time.Time
+	reader  geoReader
+}
+
+// geoDB looks IPs up in every configured database and merges the results.
+// A nil *geoDB knows nothing.
+type geoDB struct {
+	mu    sync.RWMutex
+	files []*geoFile
+}
+
+// openGeoDB opens the databases in cfg and starts watching them for
+// changes. A file that can't be opened yet is retried at each check.
+func openGeoDB(cfg GeoIPConfig) *geoDB {
+	db := &geoDB{}
+	for _, path := range cfg.Files {
+		f := &geoFile{path: path}
+		if err := f.reload(); err != nil {
+			log.Printf("GeoIP: %s\n", err.Error())
+		}
+		db.files = append(db.files, f)
+	}
+	go db.watch(cfg.checkInterval())
+	return db
+}
+
+// reload opens f's file if it changed since it was last opened, closing
+// the previous reader. The caller must hold the write lock, or own f.
+func (f *geoFile) reload() error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.reader != nil && st.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	r, err := maxminddb.Open(f.path)
+	if err != nil {
+		return err
+	}
+	if f.reader != nil {
+		f.reader.Close()
+		log.Printf("GeoIP: reloaded %s\n", f.path)
+	}
+	f.reader = r
+	f.modTime = st.ModTime()
+	return nil
+}
+
+func (db *geoDB) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, f := range db.files {
+			st, err := os.Stat(f.path)
+			if err != nil || st.ModTime().Equal(f.modTime) {
+				continue
+			}
+			// Don't swap a file that is still being written.
+			time.Sleep(time.Second)
+			db.mu.Lock()
+			err = f.reload()
+			db.mu.Unlock()
+			if err != nil {
+				log.Printf("GeoIP: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// Lookup returns what the databases know about ip.
+func (db *geoDB) Lookup(ip net.IP) geoInfo {
+	var info geoInfo
+	if db == nil || ip == nil {
+		return info
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, f := range db.files {
+		if f.reader == nil {
+			continue
+		}
+		var record map[string]any
+		if err := f.reader.Lookup(ip, &record); err != nil || record == nil {
+			continue
+		}
+		info.merge(geoFromRecord(record))
+	}
+	return info
+}
+
+// merge fills the fields of g that are still empty from o.
+func (g *geoInfo) merge(o geoInfo) {
+	if g.ASN == 0 {
+		g.ASN = o.ASN
+	}
+	if g.ASOrg == "" {
+		g.ASOrg = o.ASOrg
+	}
+	if g.Country == "" {
+		g.Country = o.Country
+	}
+}
+
+// geoFromRecord reads a MaxMind or IPinfo record.
+func geoFromRecord(record map[string]any) geoInfo {
+	var info geoInfo
+	switch v := record["autonomous_system_number"].(type) {
+	case uint64:
+		info.ASN = v
+	case uint32:
+		info.ASN = uint64(v)
+	}
+	if s, ok := record["asn"].(string); ok && info.ASN == 0 {
+		info.ASN, _ = strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 64)
+	}
+	for _, key := range []string{"autonomous_system_organization", "as_name"} {
+		if s, ok := record[key].(string); ok && info.ASOrg == "" {
+			info.ASOrg = s
+		}
+	}
+	switch v := record["country"].(type) {
+	case map[string]any:
+		info.Country, _ = v["iso_code"].(string)
+	case string:
+		info.Country = v
+	}
+	if info.Country == "" {
+		info.Country, _ = record["country_code"].(string)
+	}
+	return info
+}
+
+// geoInfoOf looks up the first IP of g's network.
+func (s *serverData) geoInfoOf(g *glineData) geoInfo {
+	return s.GeoIP.Lookup(g.ipNet.IP)
+}
+
+// setGeoInfo fills in the ASN and country fields.
+func setGeoInfo(r *RetGlineData, info geoInfo) {
+	r.ASN = info.ASN
+	r.ASOrg = info.ASOrg
+	r.Country = info.Country
+}
+
+// geoStat counts active glines for one ASN or country.
+type geoStat = client.StatsEntry
+
+type geoStats = client.Stats
+
+// topGeoStats returns the stats of m with the most glines first, at most
+// limit of them if limit > 0.
+func topGeoStats(m map[string]*geoStat, limit int) []*geoStat {
+	list := make([]*geoStat, 0, len(m))
+	for _, st := range m {
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Glines != list[j].Glines {
+			return list[i].Glines > list[j].Glines
+		}
+		if list[i].ASN != list[j].ASN {
+			return list[i].ASN < list[j].ASN
+		}
+		return list[i].Country < list[j].Country
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// GeoStats counts the active glines per ASN and per country. Glines the
+// databases know nothing about are counted under ASN 0 and country "".
+func (s *serverData) GeoStats(limit int) *geoStats {
+	stats := &geoStats{}
+	byASN := make(map[string]*geoStat)
+	byCountry := make(map[string]*geoStat)
+	s.forEachGline(func(g *glineData) bool {
+		if !g.IsGlineActive() {
+			return true
+		}
+		info := s.geoInfoOf(g)
+		stats.Glines++
+		key := strconv.FormatUint(info.ASN, 10)
+		if byASN[key] == nil {
+			byASN[key] = &geoStat{ASN: info.ASN, ASOrg: info.ASOrg}
+		}
+		byASN[key].Glines++
+		if byCountry[info.Country] == nil {
+			byCountry[info.Country] = &geoStat{Country: info.Country}
+		}
+		byCountry[info.Country].Glines++
+		return true
+	})
+	stats.ByASN = topGeoStats(byASN, limit)
+	stats.ByCountry = topGeoStats(byCountry, limit)
+	return stats
+}
+
+type api_stats_struct struct {
+	Network string `param:"network"`
+	Limit   int    `query:"limit"`
+}
+
+// statsApi returns the number of active glines per ASN and per country,
+// the top ?limit= (20 by default) of each.
+func (a *ApiData) statsApi(c echo.Context) error {
+	var in api_stats_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if in.Limit <= 0 {
+		in.Limit = 20
+	}
+	return c.JSON(http.StatusOK, s.GeoStats(in.Limit))
+}
+
+func (s *serverData) cmdGstats(inv *invocation, args []string) {
+	stats := s.GeoStats(5)
+	asns := make([]string, 0, len(stats.ByASN))
+	for _, st := range stats.ByASN {
+		asns = append(asns, fmt.Sprintf("%s: %d", geoInfo{ASN: st.ASN, ASOrg: st.ASOrg}.orUnknown(), st.Glines))
+	}
+	countries := make([]string, 0, len(stats.ByCountry))
+	for _, st := range stats.ByCountry {
+		countries = append(countries, fmt.Sprintf("%s: %d", geoInfo{Country: st.Country}.orUnknown(), st.Glines))
+	}
+	s.reply(inv,
+		fmt.Sprintf("%d active glines, %d expired since startup. Top ASNs: %s", stats.Glines, s.Expiry.Expired(), strings.Join(asns, ", ")),
+		fmt.Sprintf("Top countries: %s", strings.Join(countries, ", ")))
+}
+
+func (g geoInfo) orUnknown() string {
+	if g.Empty() {
+		return "unknown"
+	}
+	return g.String()
+}
+
+func init() {
+	registerBotCommand(&botCommand{
+		Name: "gstats",
+		Help: "Show which ASNs and countries have the most active glines.",
+		Run:  (*serverData).cmdGstats,
+	})
+}
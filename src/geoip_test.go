@@ -0,0 +1,73 @@
+package ircglineapi
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeGeoReader answers with the record of the first network containing
+// the IP.
+type fakeGeoReader map[string]map[string]any
+
+func (r fakeGeoReader) Lookup(ip net.IP, result any) error {
+	for cidr, record := range r {
+		if _, n, _ := net.ParseCIDR(cidr); n.Contains(ip) {
+			*result.(*map[string]any) = record
+		}
+	}
+	return nil
+}
+
+func (r fakeGeoReader) Close() error { return nil }
+
+func TestGeoFromRecord(t *testing.T) {
+	maxmindASN := map[string]any{"autonomous_system_number": uint64(15169), "autonomous_system_organization": "Google LLC"}
+	maxmindCountry := map[string]any{"country": map[string]any{"iso_code": "US", "names": map[string]any{"en": "United States"}}}
+	ipinfo := map[string]any{"asn": "AS3215", "as_name": "Orange S.A.", "country": "FR", "country_name": "France"}
+	cases := []struct {
+		record map[string]any
+		want   string
+	}{
+		{maxmindASN, "AS15169 Google LLC"},
+		{maxmindCountry, "US"},
+		{ipinfo, "AS3215 Orange S.A., FR"},
+		{map[string]any{}, ""},
+	}
+	for _, c := range cases {
+		if got := geoFromRecord(c.record).String(); got != c.want {
+			t.Errorf(`geoFromRecord(%v) = %q. Want %q`, c.record, got, c.want)
+		}
+	}
+}
+
+func TestGeoLookupAndStats(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "undernet", Server: "hidden.undernet.org", Nick: "GLG1"})
+	s.GeoIP = &geoDB{files: []*geoFile{
+		{reader: fakeGeoReader{
+			"10.0.0.0/16": {"autonomous_system_number": uint64(64500), "autonomous_system_organization": "Example Net"},
+			"10.1.0.0/16": {"autonomous_system_number": uint64(64501)},
+		}},
+		{reader: fakeGeoReader{"10.0.0.0/8": {"country": map[string]any{"iso_code": "CA"}}}},
+	}}
+	addTestGline(s, "*@10.0.0.1", 4102444800, 1000, "drone", true)
+	addTestGline(s, "*@10.0.0.2", 4102444800, 1000, "drone", true)
+	addTestGline(s, "*@10.1.0.1", 4102444800, 1000, "drone", true)
+	addTestGline(s, "*@192.0.2.1", 4102444800, 1000, "drone", true)
+	addTestGline(s, "*@10.0.0.3", 4102444800, 1000, "expired", false)
+
+	active, _, _ := s.CheckGline("10.0.0.1", false)
+	if got := s.formatGlineLineDetailed(active[0]); !strings.Contains(got, "(AS64500 Example Net, CA)") {
+		t.Errorf(`formatGlineLineDetailed(10.0.0.1) = %q`, got)
+	}
+	stats := s.GeoStats(0)
+	if stats.Glines != 4 || len(stats.ByASN) != 3 || len(stats.ByCountry) != 2 {
+		t.Fatalf("GeoStats() = %d glines, %d ASNs, %d countries. Want 4, 3, 2", stats.Glines, len(stats.ByASN), len(stats.ByCountry))
+	}
+	if top := stats.ByASN[0]; top.ASN != 64500 || top.Glines != 2 {
+		t.Errorf("GeoStats() top ASN = %+v. Want AS64500 with 2 glines", top)
+	}
+	if top := stats.ByCountry[0]; top.Country != "CA" || top.Glines != 3 {
+		t.Errorf("GeoStats() top country = %+v. Want CA with 3 glines", top)
+	}
+}
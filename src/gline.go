@@ -38,14 +38,17 @@ func IsGlineIDFormat(s string) bool {
 }
 
 type glineData struct {
-	ipNet     net.IPNet
-	user      string
-	mask      string
-	reason    string
-	id        string
-	expireTS  int64
-	lastModTS int64
-	active    bool
+	ipNet        net.IPNet
+	user         string
+	mask         string
+	setter       string // server that set the gline, when learnt from a notice
+	reason       string
+	id           string
+	expireTS     int64
+	lastModTS    int64
+	active       bool
+	supersededTS int64 // set on frozen clones: when a newer ID replaced this one
+	info         reasonInfo
 }
 
 type glinesData struct {
@@ -88,6 +91,19 @@ func (g *glineData) ID() string {
 	return g.id
 }
 
+func (g *glineData) Setter() string {
+	return g.setter
+}
+
+func (g *glineData) Reason() string {
+	return g.reason
+}
+
+// Info returns the fields parsed from the reason.
+func (g *glineData) Info() reasonInfo {
+	return g.info
+}
+
 // Clone returns an independent copy of g, frozen at its current state. A
 // shallow copy is safe: Update never mutates ipNet/user/mask after
 // construction, only active/reason/expireTS/lastModTS/id.
@@ -164,12 +180,83 @@ func newGlineData(ipNet net.IPNet, user, mask string, expireTS, lastModTS int64,
 	}
 }
 
+const (
+	glineAdded    = "add"
+	glineModified = "modify"
+	glineExpired  = "expire"
+)
+
+// glineChange describes one change applied by AddOrUpdateGline, or a gline
+// reaching its expiration time.
+type glineChange struct {
+	Kind      string     // glineAdded, glineModified or glineExpired
+	Gline     *glineData // snapshot taken right after the change
+	WasActive bool       // whether the gline was active before; false when added
+	Setter    string     // server named in the notice; "" for the /GLINE listing
+	Line      string     // raw IRC line the change came from
+	Seq       uint64     // number in the change feed
+}
+
+func newGlineChange(kind string, g *glineData, wasActive bool, setter, line string) *glineChange {
+	return &glineChange{
+		Kind:      kind,
+		Gline:     g.Clone(),
+		WasActive: wasActive,
+		Setter:    setter,
+		Line:      line,
+	}
+}
+
+// Live reports whether the change was announced by a server notice or is
+// an expiration, as opposed to being (re)learnt from the /GLINE listing
+// sent on connect.
+func (c *glineChange) Live() bool {
+	return c.Setter != "" || c.Kind == glineExpired
+}
+
+// Activated reports whether the change created an active gline or turned an
+// inactive one back on.
+func (c *glineChange) Activated() bool {
+	return !c.WasActive && c.Gline.IsGlineActive()
+}
+
+// OnGlineChange registers fn to be called after every change applied by
+// AddOrUpdateGline. fn runs on the caller's goroutine, without s.mu held.
+func (s *serverData) OnGlineChange(fn func(*glineChange)) {
+	s.glineObservers = append(s.glineObservers, fn)
+}
+
+func (s *serverData) notifyGlineChange(c *glineChange) {
+	for _, fn := range s.glineObservers {
+		fn(c)
+	}
+}
+
 // Updates existing glineData information based on gline mask.
+// setter is the server named in the notice, or "" when unknown (e.g. from
+// the /GLINE listing).
 // Returns true if ip gline mask exists in current glineData struct. False otherwise.
-func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expireTS, lastModTS int64, reason string, active *bool, line string) bool {
+// Observers registered with OnGlineChange are notified once the change is applied.
+func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask, setter string, expireTS, lastModTS int64, reason string, active *bool, line string) bool {
+	s.mu.Lock()
+	change := s.applyGline(ipNet, user, mask, setter, expireTS, lastModTS, reason, active, line)
+	if change != nil {
+		s.Changes.Append(change)
+	}
+	s.mu.Unlock()
+	if change == nil {
+		return false
+	}
+	s.notifyGlineChange(change)
+	return true
+}
+
+// applyGline does the work of AddOrUpdateGline with s.mu held, and returns
+// what changed, or nil if the mask couldn't be parsed.
+func (s *serverData) applyGline(ipNet net.IPNet, user, mask, setter string, expireTS, lastModTS int64, reason string, active *bool, line string) *glineChange {
 	mask_l := strings.Split(mask, "@")
 	if len(mask_l) < 2 {
-		return false
+		return nil
 	}
 	ip := mask_l[1]
 
@@ -180,7 +267,7 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 		_, tmp_ipnet, err2 := net.ParseCIDR(ip)
 		if err2 != nil {
 			debugLogf("net.ParseCIDR(%s) failed. Line: %s\n", ip, line)
-			return false
+			return nil
 		}
 		entries, err = s.Cranger.CoveringOrCoveredNetworks(*tmp_ipnet)
 	}
@@ -201,18 +288,25 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 				emask := entry.Mask()
 				if strings.EqualFold(mask, emask) {
 					debugLogf("serverData.UpdateGline(): Update gline mask=%s\n", mask)
+					wasActive := entry.IsGlineActive()
 					oldID := entry.ID()
 					newID := parseGlineID(reason)
 					if oldID != "" && newID != "" && newID != oldID {
 						// The ID is being reassigned: freeze the pre-update
 						// state under its old ID so it stays viewable.
-						s.GlinesByID[oldID] = entry.Clone()
+						frozen := entry.Clone()
+						frozen.supersededTS = time.Now().Unix()
+						s.GlinesByID[oldID] = frozen
 					}
 					entry.Update(active, expireTS, reason)
+					entry.info = s.Reasons.Parse(entry.reason)
+					if entry.setter == "" {
+						entry.setter = setter
+					}
 					if id := entry.ID(); id != "" {
 						s.GlinesByID[id] = entry
 					}
-					return true
+					return newGlineChange(glineModified, entry, wasActive, setter, line)
 				}
 			}
 			if active == nil {
@@ -221,11 +315,13 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 			// Add new gline, but another gline exists for that IP, but with a differnet user@.
 			debugLogf("serverData.UpdateGline(): Add new gline for mask=%s, but at least one other gline exists with another user for that IP.\n", mask)
 			newGline := newGlineData(gd.IpNet, user, mask, expireTS, lastModTS, reason, true)
+			newGline.setter = setter
+			newGline.info = s.Reasons.Parse(reason)
 			gd.Glines = append(gd.Glines, newGline)
 			if id := newGline.ID(); id != "" {
 				s.GlinesByID[id] = newGline
 			}
-			return true
+			return newGlineChange(glineAdded, newGline, false, setter, line)
 		}
 	}
 	// Add new gline
@@ -242,6 +338,8 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 		newActive = *active
 	}
 	newGline := newGlineData(ipNet, user, mask, expireTS, lastModTS, reason, newActive)
+	newGline.setter = setter
+	newGline.info = s.Reasons.Parse(reason)
 	if id := newGline.ID(); id != "" {
 		s.GlinesByID[id] = newGline
 	}
@@ -249,7 +347,7 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 	gList = append(gList, newGline)
 	glineDataList := newGlinesData(ipNet, gList)
 	s.Cranger.Insert(glineDataList)
-	return true
+	return newGlineChange(glineAdded, newGline, false, setter, line)
 }
 
 // This method accepts an IP as parameter and returns two lists:
@@ -259,7 +357,19 @@ func (s *serverData) AddOrUpdateGline(ipNet net.IPNet, user, mask string, expire
 //
 //	If a gline exists on *@1.2.3.0/24, CheckGline("1.2.3.0/31") will return nothing
 //	If a gline exists on *@1.2.3.0/24, CheckGline("1.2.0.0/16") will return the gline
+//
+// The glines returned are snapshots: the trie's own are only read under
+// s.mu, as GNOTICEs update them in place.
 func (s *serverData) CheckGline(ip string, exactCidr bool) ([]*glineData, []*glineData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	active, inactive, err := s.checkGline(ip, exactCidr)
+	return cloneGlines(active), cloneGlines(inactive), err
+}
+
+// checkGline is CheckGline without the lock and the copies. It must be
+// called with s.mu held.
+func (s *serverData) checkGline(ip string, exactCidr bool) ([]*glineData, []*glineData, error) {
 	var ipnet *net.IPNet
 	entries, err := s.Cranger.ContainingNetworks(net.ParseIP(ip))
 	if err != nil {
@@ -308,20 +418,97 @@ func (s *serverData) CheckGline(ip string, exactCidr bool) ([]*glineData, []*gli
 // same mask, it is a frozen historical snapshot that no longer appears in
 // the trie, so it can never duplicate an entry in the "related" list.
 func (s *serverData) CheckGlineByID(id string) ([]*glineData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	g, ok := s.GlinesByID[id]
 	if !ok {
 		return nil, nil
 	}
-	active, inactive, err := s.CheckGline(g.ipNet.IP.String(), false)
+	active, inactive, err := s.checkGline(g.ipNet.IP.String(), false)
 	if err != nil {
-		return []*glineData{g}, nil
+		return []*glineData{g.Clone()}, nil
 	}
 	related := make([]*glineData, 0, len(active)+len(inactive))
 	for _, e := range append(active, inactive...) {
 		if e != g {
-			related = append(related, e)
+			related = append(related, e.Clone())
 		}
 	}
 	sort.Slice(related, func(i, j int) bool { return related[i].expireTS > related[j].expireTS })
-	return append([]*glineData{g}, related...), nil
+	return append([]*glineData{g.Clone()}, related...), nil
+}
+
+func cloneGlines(list []*glineData) []*glineData {
+	for i, g := range list {
+		list[i] = g.Clone()
+	}
+	return list
+}
+
+// walkBlockEntries is the number of trie entries above which forEachGline
+// splits an address block in two, to hold the lock briefly.
+const walkBlockEntries = 256
+
+// walkRoots are the address blocks forEachGline starts from.
+var walkRoots = []net.IPNet{
+	{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+	{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+}
+
+// splitBlock returns the two halves of block.
+func splitBlock(block net.IPNet) (net.IPNet, net.IPNet) {
+	ones, bits := block.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	hi := make(net.IP, len(block.IP))
+	copy(hi, block.IP)
+	hi[ones/8] |= 0x80 >> (ones % 8)
+	return net.IPNet{IP: block.IP, Mask: mask}, net.IPNet{IP: hi, Mask: mask}
+}
+
+// forEachGline calls fn for every gline in the trie, active or not. The
+// trie is walked one address block at a time, so the lock is only held
+// briefly and the whole gline set is never copied at once. Only the
+// populated blocks are walked: a block is split in two while it holds more
+// than walkBlockEntries entries. fn runs without the lock held and
+// receives snapshots; returning false stops the walk.
+func (s *serverData) forEachGline(fn func(g *glineData) bool) {
+	for _, root := range walkRoots {
+		if !s.walkBlock(root, fn) {
+			return
+		}
+	}
+}
+
+// walkBlock is forEachGline for the glines starting in block.
+func (s *serverData) walkBlock(block net.IPNet, fn func(g *glineData) bool) bool {
+	s.mu.RLock()
+	entries, err := s.Cranger.CoveringOrCoveredNetworks(block)
+	if ones, bits := block.Mask.Size(); err == nil && len(entries) > walkBlockEntries && ones < bits {
+		s.mu.RUnlock()
+		lo, hi := splitBlock(block)
+		return s.walkBlock(lo, fn) && s.walkBlock(hi, fn)
+	}
+	var snapshot []*glineData
+	for _, e := range entries {
+		gd, ok := e.(*glinesData)
+		// Networks larger than the block are visited from the block
+		// holding their first address only.
+		if !ok || !block.Contains(gd.IpNet.IP) {
+			continue
+		}
+		for _, g := range gd.Glines {
+			snapshot = append(snapshot, g.Clone())
+		}
+	}
+	s.mu.RUnlock()
+	if err != nil {
+		debugLogf("serverData.forEachGline(): %s: %s\n", block.String(), err.Error())
+		return true
+	}
+	for _, g := range snapshot {
+		if !fn(g) {
+			return false
+		}
+	}
+	return true
 }
@@ -1,8 +1,10 @@
 package ircglineapi
 
 import (
+	"fmt"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestIs_valid_ipValid(t *testing.T) {
@@ -150,3 +152,34 @@ func TestGlineDataClone(t *testing.T) {
 		t.Errorf(`clone.reason = %q after mutating original. Want unchanged`, clone.reason)
 	}
 }
+
+// CheckGline runs concurrently with the GNOTICEs updating the glines it
+// returns. Run with -race.
+func TestCheckGlineConcurrentUpdate(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "racenet", Server: "hidden.undernet.org", Nick: "GLR2"})
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.97.0.1", now+3600, now, "spam [D1-1]", true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			addTestGline(s, "*@10.97.0.1", now+3600+int64(i), now, fmt.Sprintf("spam %d [D1-1]", i), true)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		active, _, _ := s.CheckGline("10.97.0.1", false)
+		for _, g := range active {
+			_ = g.Reason() + g.Mask()
+			_ = g.ExpireTS()
+		}
+		entries, _ := s.CheckGlineByID("D1-1")
+		for _, g := range entries {
+			_ = g.Reason()
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package ircglineapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/cidranger"
+	"github.com/labstack/echo/v4"
+)
+
+// HistoryConfig makes the gline history survive restarts. The history
+// records every state each gline mask went through, so lookups can tell
+// which glines were in force at a past moment, after they expired or were
+// replaced.
+type HistoryConfig struct {
+	// JSON lines file the history is loaded from and appended to. Without
+	// it, the history starts over at each restart.
+	File string
+}
+
+// glineVersion is the state of a gline mask from Since until the next
+// version of the same mask.
+type glineVersion struct {
+	Since int64
+	Gline *glineData
+}
+
+// InForce reports whether the gline applied at time ts, assuming ts is
+// before the next version.
+func (v *glineVersion) InForce(ts int64) bool {
+	return ts >= v.Since && v.Gline.active && v.Gline.expireTS > ts
+}
+
+// historyRecord is one line of the history file.
+type historyRecord struct {
+	Since     int64  `json:"since"`
+	Mask      string `json:"mask"`
+	CIDR      string `json:"cidr"`
+	Setter    string `json:"setter,omitempty"`
+	Reason    string `json:"reason"`
+	ExpireTS  int64  `json:"expirets"`
+	LastModTS int64  `json:"lastmodts"`
+	Active    bool   `json:"active"`
+}
+
+// historyNode is a ranger entry holding the versions of every mask on one
+// network, by lowercased mask, oldest first.
+type historyNode struct {
+	ipNet net.IPNet
+	masks map[string][]*glineVersion
+}
+
+func (n *historyNode) Network() net.IPNet {
+	return n.ipNet
+}
+
+type glineHistory struct {
+	mu      sync.RWMutex
+	ranger  cidranger.Ranger
+	nodes   map[string]*historyNode // by CIDR
+	file    string
+	out     *os.File
+	reasons *reasonParser
+}
+
+// newGlineHistory loads the history from file, if any, and opens it for
+// appending.
+func newGlineHistory(file string, reasons *reasonParser) *glineHistory {
+	h := &glineHistory{
+		ranger:  cidranger.NewPCTrieRanger(),
+		nodes:   make(map[string]*historyNode),
+		file:    file,
+		reasons: reasons,
+	}
+	if file == "" {
+		return h
+	}
+	f, err := os.Open(file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Can't read history file:", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			var rec historyRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				log.Printf("History file line %d: %s\n", n, err.Error())
+				continue
+			}
+			h.add(rec)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			log.Fatal("Can't read history file:", err)
+		}
+	}
+	if h.out, err = os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+		log.Fatal("Can't open history file:", err)
+	}
+	return h
+}
+
+// maskIPNet returns the network of a user@host gline mask.
+func maskIPNet(mask string) (net.IPNet, bool) {
+	i := strings.LastIndex(mask, "@")
+	if i == -1 {
+		return net.IPNet{}, false
+	}
+	_, ipNet, err := net.ParseCIDR(AddCidrToIP(mask[i+1:]))
+	if err != nil {
+		return net.IPNet{}, false
+	}
+	return *ipNet, true
+}
+
+func (rec historyRecord) version(reasons *reasonParser) (*glineVersion, bool) {
+	_, ipNet, err := net.ParseCIDR(rec.CIDR)
+	if err != nil {
+		return nil, false
+	}
+	user := rec.Mask
+	if i := strings.LastIndex(user, "@"); i != -1 {
+		user = user[:i]
+	}
+	g := newGlineData(*ipNet, user, rec.Mask, rec.ExpireTS, rec.LastModTS, rec.Reason, rec.Active)
+	g.setter = rec.Setter
+	g.info = reasons.Parse(rec.Reason)
+	return &glineVersion{Since: rec.Since, Gline: g}, true
+}
+
+func newHistoryRecord(since int64, g *glineData) historyRecord {
+	ipNet, ok := maskIPNet(g.mask)
+	if !ok {
+		ipNet = g.ipNet
+	}
+	return historyRecord{
+		Since:     since,
+		Mask:      g.mask,
+		CIDR:      ipNet.String(),
+		Setter:    g.setter,
+		Reason:    g.reason,
+		ExpireTS:  g.expireTS,
+		LastModTS: g.lastModTS,
+		Active:    g.active,
+	}
+}
+
+// add inserts rec among the versions of its mask. It returns false if rec
+// doesn't change anything. The caller must hold h.mu, or own h.
+func (h *glineHistory) add(rec historyRecord) bool {
+	v, ok := rec.version(h.reasons)
+	if !ok {
+		return false
+	}
+	node := h.nodes[rec.CIDR]
+	if node == nil {
+		node = &historyNode{ipNet: v.Gline.ipNet, masks: make(map[string][]*glineVersion)}
+		h.nodes[rec.CIDR] = node
+		h.ranger.Insert(node)
+	}
+	key := strings.ToLower(rec.Mask)
+	versions := node.masks[key]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Since > v.Since })
+	// Relisting a gline on reconnect repeats its state: only keep changes.
+	if i > 0 && sameGlineState(versions[i-1].Gline, v.Gline) {
+		return false
+	}
+	versions = append(versions, nil)
+	copy(versions[i+1:], versions[i:])
+	versions[i] = v
+	node.masks[key] = versions
+	return true
+}
+
+func sameGlineState(a, b *glineData) bool {
+	return a.active == b.active && a.expireTS == b.expireTS && a.reason == b.reason
+}
+
+// Record is a gline observer adding the new state of the gline to the
+// history. The state applies from the gline's last modification.
+func (h *glineHistory) Record(c *glineChange) {
+	since := c.Gline.lastModTS
+	if since == 0 {
+		since = time.Now().Unix()
+	}
+	rec := newHistoryRecord(since, c.Gline)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.add(rec) || h.out == nil {
+		return
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("glineHistory.Record():", err.Error())
+		return
+	}
+	if _, err := h.out.Write(append(b, '\n')); err != nil {
+		log.Println("glineHistory.Record():", err.Error())
+	}
+}
+
+// At returns the versions of the glines matching an IP or a CIDR that were
+// in force at time ts, the latest expiring first. CIDRs match the same way
+// as with CheckGline.
+func (h *glineHistory) At(ip string, ts int64) ([]*glineVersion, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	entries, err := h.ranger.ContainingNetworks(net.ParseIP(ip))
+	if err != nil {
+		_, ipNet, err2 := net.ParseCIDR(AddCidrToIP(ip))
+		if err2 != nil {
+			return nil, err2
+		}
+		if entries, err = h.ranger.CoveringOrCoveredNetworks(*ipNet); err != nil {
+			return nil, err
+		}
+	}
+	list := make([]*glineVersion, 0)
+	for _, e := range entries {
+		node, ok := e.(*historyNode)
+		if !ok {
+			continue
+		}
+		for _, versions := range node.masks {
+			i := sort.Search(len(versions), func(i int) bool { return versions[i].Since > ts })
+			if i > 0 && versions[i-1].InForce(ts) {
+				v := versions[i-1]
+				list = append(list, &glineVersion{Since: v.Since, Gline: v.Gline.Clone()})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Gline.expireTS > list[j].Gline.expireTS })
+	return list, nil
+}
+
+// Prune forgets what the history knows about the time before cutoff: the
+// versions replaced before then, and the masks that stopped being in force
+// before then. The history file is rewritten if anything was dropped. It
+// returns the number of versions dropped.
+func (h *glineHistory) Prune(cutoff int64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	dropped := 0
+	for cidr, node := range h.nodes {
+		for key, versions := range node.masks {
+			i := sort.Search(len(versions), func(i int) bool { return versions[i].Since > cutoff })
+			if i > 1 {
+				dropped += i - 1
+				versions = versions[i-1:]
+			}
+			last := versions[len(versions)-1]
+			if since := last.Gline.inactiveSince(cutoff); since != 0 && since < cutoff {
+				dropped += len(versions)
+				delete(node.masks, key)
+				continue
+			}
+			node.masks[key] = versions
+		}
+		if len(node.masks) == 0 {
+			if _, err := h.ranger.Remove(node.ipNet); err != nil {
+				log.Printf("glineHistory.Prune(): removing %s failed: %s\n", cidr, err.Error())
+				continue
+			}
+			delete(h.nodes, cidr)
+		}
+	}
+	if dropped > 0 && h.out != nil {
+		if err := h.rewrite(); err != nil {
+			log.Println("glineHistory.Prune():", err.Error())
+		}
+	}
+	return dropped
+}
+
+// rewrite replaces the history file with the versions still in memory.
+// The caller must hold h.mu.
+func (h *glineHistory) rewrite() error {
+	tmp := h.file + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, node := range h.nodes {
+		for _, versions := range node.masks {
+			for _, v := range versions {
+				if err := enc.Encode(newHistoryRecord(v.Since, v.Gline)); err != nil {
+					f.Close()
+					return err
+				}
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.file); err != nil {
+		return err
+	}
+	h.out.Close()
+	h.out, err = os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return err
+}
+
+// Layouts accepted for past moments, in UTC unless they carry an offset.
+var atTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseAtTime parses a unix timestamp or a date like "2026-10-18",
+// "2026-10-18 14:30" or "2026-10-18T14:30:00+02:00".
+func parseAtTime(str string) (int64, error) {
+	str = strings.TrimSpace(str)
+	if ts, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return ts, nil
+	}
+	for _, layout := range atTimeLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time: %s", str)
+}
+
+func formatHistoryTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// formatGlineVersionLine formats a gline as it was at a past moment.
+func formatGlineVersionLine(v *glineVersion) string {
+	return fmt.Sprintf("%s (since %s, until %s): %s", v.Gline.Mask(), formatHistoryTime(v.Since), formatHistoryTime(v.Gline.ExpireTS()), v.Gline.reason)
+}
+
+// cmdGlineAt is !g <IP|CIDR> @<date>.
+func (s *serverData) cmdGlineAt(inv *invocation, target, at string) {
+	if IsGlineIDFormat(target) {
+		s.reply(inv, "Past lookups take an IP or a CIDR, not a gline ID.")
+		return
+	}
+	ts, err := parseAtTime(at)
+	if err != nil {
+		s.reply(inv, fmt.Sprintf("Invalid date: %s. Use e.g. 2026-10-18 or 2026-10-18T14:30", at))
+		return
+	}
+	versions, err := s.History.At(target, ts)
+	if err != nil {
+		return
+	}
+	entries := make([]*glineData, len(versions))
+	since := make(map[*glineData]int64, len(versions))
+	for i, v := range versions {
+		entries[i] = v.Gline
+		since[v.Gline] = v.Since
+	}
+	format := func(g *glineData) string {
+		return formatGlineVersionLine(&glineVersion{Since: since[g], Gline: g})
+	}
+	s.replyGlines(inv, entries, format,
+		fmt.Sprintf("No gline matched %s at %s", target, formatHistoryTime(ts)))
+}
+
+// glineAtApi answers /api2/glinelookup/:network/:ip?at=<time> with the
+// glines that were in force at that time.
+func (a *ApiData) glineAtApi(c echo.Context, s *serverData, in api_struct) error {
+	ts, err := parseAtTime(in.At)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid time")
+	}
+	versions, err := s.History.At(in.Ip, ts)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Invalid IP")
+	}
+	entries := make([]*glineData, len(versions))
+	for i, v := range versions {
+		entries[i] = v.Gline
+	}
+	list := s.lookupRetGlineDataList(entries, false, parseAcceptLanguage(c.Request().Header.Get("Accept-Language")))
+	return c.JSON(http.StatusOK, &list)
+}
@@ -0,0 +1,157 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func historyReasons(t *testing.T, h *glineHistory, ip string, ts int64) []string {
+	t.Helper()
+	versions, err := h.At(ip, ts)
+	if err != nil {
+		t.Fatalf(`At(%s, %d) error: %s`, ip, ts, err.Error())
+	}
+	reasons := make([]string, 0, len(versions))
+	for _, v := range versions {
+		reasons = append(reasons, v.Gline.Reason())
+	}
+	return reasons
+}
+
+func TestHistoryAt(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	s := newTestServer(&Configuration{
+		Network: "historynet",
+		Server:  "hidden.undernet.org",
+		Nick:    "GLH1",
+		History: HistoryConfig{File: file},
+	})
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.20.0.1", now+86400, now-7200, "spam - ID: D1000-1", true)
+	addTestGline(s, "*@10.30.0.0/16", now-3600, now-10800, "old proxy range", true)
+	// Relisted on reconnect: nothing changes.
+	addTestGline(s, "*@10.20.0.1", now+86400, now-7200, "spam - ID: D1000-1", true)
+	// Deactivated now.
+	inactive := false
+	_, ipNet, _ := net.ParseCIDR("10.20.0.1/32")
+	s.AddOrUpdateGline(*ipNet, "*", "*@10.20.0.1", "", 0, 0, "", &inactive, "")
+
+	var tests = []struct {
+		ip   string
+		ts   int64
+		want []string
+	}{
+		{"10.20.0.1", now - 8000, []string{}},
+		{"10.20.0.1", now - 3600, []string{"spam - ID: D1000-1"}},
+		{"10.20.0.1", now + 60, []string{}},
+		{"10.30.1.2", now - 7200, []string{"old proxy range"}},
+		{"10.30.1.2", now - 60, []string{}},
+		{"10.0.0.0/8", now - 5000, []string{"spam - ID: D1000-1", "old proxy range"}},
+	}
+	check := func(h *glineHistory) {
+		for _, tt := range tests {
+			got := historyReasons(t, h, tt.ip, tt.ts)
+			if len(got) != len(tt.want) {
+				t.Errorf(`At(%s, now%+d) = %q. Want %q`, tt.ip, tt.ts-now, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf(`At(%s, now%+d) = %q. Want %q`, tt.ip, tt.ts-now, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+	check(s.History)
+	// The same answers after a restart.
+	check(newGlineHistory(file, s.Reasons))
+}
+
+func TestHistoryPrune(t *testing.T) {
+	h := newGlineHistory("", newReasonParser(ReasonParserConfig{}))
+	record := func(mask, reason string, lastModTS, expireTS int64) {
+		ipNet, _ := maskIPNet(mask)
+		g := newGlineData(ipNet, "*", mask, expireTS, lastModTS, reason, true)
+		h.Record(newGlineChange(glineModified, g, true, "", ""))
+	}
+	now := int64(2000000000)
+	record("*@10.40.0.1", "long gone", now-30*86400, now-20*86400)
+	record("*@10.50.0.1", "first", now-30*86400, now+86400)
+	record("*@10.50.0.1", "second", now-20*86400, now+86400)
+	record("*@10.50.0.1", "third", now-86400, now+86400)
+
+	cutoff := now - 7*86400
+	if got := h.Prune(cutoff); got != 2 {
+		t.Errorf(`Prune() = %d. Want 2: "long gone" and "first"`, got)
+	}
+	if got := historyReasons(t, h, "10.50.0.1", cutoff); len(got) != 1 || got[0] != "second" {
+		t.Errorf(`At(10.50.0.1, cutoff) = %q. Want ["second"]`, got)
+	}
+	if got := historyReasons(t, h, "10.50.0.1", now); len(got) != 1 || got[0] != "third" {
+		t.Errorf(`At(10.50.0.1, now) = %q. Want ["third"]`, got)
+	}
+	if got := historyReasons(t, h, "10.40.0.1", now-25*86400); len(got) != 0 {
+		t.Errorf(`At(10.40.0.1, now-25d) = %q. Want []`, got)
+	}
+}
+
+func TestParseAtTime(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want int64
+	}{
+		{"1791000000", 1791000000},
+		{"2026-10-18", 1792281600},
+		{"2026-10-18 14:30", 1792281600 + 14*3600 + 30*60},
+		{"2026-10-18T14:30:00+02:00", 1792281600 + 12*3600 + 30*60},
+	}
+	for _, tt := range tests {
+		if got, err := parseAtTime(tt.in); err != nil || got != tt.want {
+			t.Errorf(`parseAtTime(%s) = %d, %v. Want %d`, tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseAtTime("yesterday"); err == nil {
+		t.Errorf(`parseAtTime(yesterday) succeeded. Want an error`)
+	}
+}
+
+func TestGlineLookupApiAt(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "historynet3", Server: "hidden.undernet.org", Nick: "GLH3"})
+	s.NetworkName = "historynet3"
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.60.0.1", now-3600, now-7200, "expired an hour ago", true)
+
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/glinelookup/:network/:ip", a.glineLookupApi)
+	cases := map[string]int{
+		"":                                       1, // current lookups include expired glines
+		"?at=" + strconv.FormatInt(now-5400, 10): 1,
+		"?at=" + strconv.FormatInt(now-60, 10):   0,
+		"?at=" + strconv.FormatInt(now-9000, 10): 0,
+	}
+	for query, want := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api2/glinelookup/historynet3/10.60.0.1"+query, nil)
+		e.ServeHTTP(w, r)
+		var list []RetGlineData
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != want {
+			t.Errorf(`glinelookup%s = %d %s. Want %d glines`, query, w.Code, w.Body.String(), want)
+		}
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api2/glinelookup/historynet3/10.60.0.1?at=yesterday", nil)
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf(`glinelookup?at=yesterday = %d. Want %d`, w.Code, http.StatusBadRequest)
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	irc "github.com/fluffle/goirc/client"
@@ -37,6 +37,7 @@ func debugLogf(format string, v ...interface{}) {
 }
 
 type serverData struct {
+	mu                   sync.RWMutex // guards Cranger and GlinesByID
 	Conn                 *irc.Conn
 	Config               *Configuration
 	ServerName           string
@@ -44,8 +45,26 @@ type serverData struct {
 	LastGlineCmdIssuedTS int64
 	Cranger              cidranger.Ranger
 	GlinesByID           map[string]*glineData
-	LoggedInToOperServ   bool
-	LastLoginAttempt     int64
+	glineObservers       []func(*glineChange)
+	OperServ             *operServAuth
+	Whois                *whoisCache
+	Audit                *auditLog
+	Out                  *outputQueue
+	Watchlists           *watchlists
+	Events               *eventBus
+	Reasons              *reasonParser
+	Explanations         *explanationCatalog
+	GeoIP                *geoDB
+	History              *glineHistory
+	Changes              *changeLog
+	Expiry               *expiryQueue
+	ConnState            *connState
+	Keepalive            *keepalive
+	Remover              GlineRemover
+	Removals             *removalWaiters
+	Approvals            *approvalQueue
+	Schedule             *scheduler
+	Announcer            *announcer
 	Quit                 chan bool
 }
 
@@ -59,9 +78,39 @@ func (s serversType) NewServerInfos(conn *irc.Conn, config *Configuration) *serv
 		LastGlineCmdIssuedTS: 0,
 		Cranger:              cidranger.NewPCTrieRanger(),
 		GlinesByID:           make(map[string]*glineData),
-		LoggedInToOperServ:   false,
-		LastLoginAttempt:     0,
-	}
+		OperServ:             newOperServAuth(config),
+		Whois:                newWhoisCache(),
+		Watchlists:           newWatchlists(config.Watchlists.File),
+		Events:               newEventBus(),
+		Reasons:              newReasonParser(config.ReasonParser),
+		Explanations:         newExplanationCatalog(config.Explanations),
+		Changes:              newChangeLog(config.Changes),
+		Expiry:               newExpiryQueue(),
+		ConnState:            newConnState(),
+		Keepalive:            &keepalive{},
+		Removals:             newRemovalWaiters(),
+		Approvals:            newApprovalQueue(),
+		Schedule:             newScheduler(config.Schedule.File),
+		Announcer:            newAnnouncer(),
+		Quit:                 make(chan bool),
+	}
+	newData.History = newGlineHistory(config.History.File, newData.Reasons)
+	newData.Remover = newData.newGlineRemover(config.Remover)
+	compileSafeguards(&config.Safeguards)
+	compileDNSBL(&config.DNSBL)
+	compileApprovals(&config.Approvals)
+	checkAnnounceRoutes(&config.Announce)
+	newData.Out = newOutputQueue(config.Output, func(target, msg string) {
+		if newData.Conn.Connected() {
+			newData.Conn.Privmsg(target, msg)
+		}
+	})
+	newData.OnGlineChange(newData.History.Record)
+	newData.OnGlineChange(newData.Expiry.Schedule)
+	newData.OnGlineChange(newData.Removals.Notify)
+	newData.OnGlineChange(newData.publishGlineChange)
+	newData.OnGlineChange(newData.checkWatchlists)
+	newData.OnGlineChange(newData.checkSafeguards)
 	s[conn] = newData
 	return newData
 }
@@ -90,27 +139,49 @@ func Irc_init(config *Configuration) *serverData {
 	irccfg.Me.Ident = config.Ident
 	irccfg.Me.Name = config.Name
 	irccfg.NewNick = func(n string) string { return n + "^" }
+	// Pings are sent by keepaliveLoop, which also watches for the PONGs.
+	irccfg.PingFreq = 0
+	if config.ACL.RequestAccountTag {
+		irccfg.EnableCapabilityNegotiation = true
+		irccfg.Capabilites = append(irccfg.Capabilites, "account-tag")
+	}
 	c := irc.Client(irccfg)
 	s := servers.NewServerInfos(c, config)
+	if config.Retention.Enabled() {
+		go s.compactLoop()
+	}
+	go s.expiryLoop()
+	s.Schedule.Start(s.runScheduledJob)
+	if len(config.GeoIP.Files) > 0 {
+		s.GeoIP = openGeoDB(config.GeoIP)
+	}
+	if config.DNSBL.Listen != "" {
+		s.startDNSBL()
+	}
+	if config.ACL.AuditLog != "" {
+		audit, err := openAuditLog(config.ACL.AuditLog)
+		if err != nil {
+			log.Fatal("Can't open audit log:", err)
+		}
+		s.Audit = audit
+	}
 
 	c.HandleFunc(irc.CONNECTED, handleConnect)
-	// And a signal on disconnect
-	s.Quit = make(chan bool)
-	c.HandleFunc(irc.DISCONNECTED,
-		func(conn *irc.Conn, line *irc.Line) {
-			fmt.Printf("Disconnected from IRC server. Reconnecting in %d seconds.\n", s.Config.ReconnWaitTime)
-			time.Sleep(time.Duration(s.Config.ReconnWaitTime) * time.Second)
-			s.Connect()
-			s.Quit <- true // Ne fonctionne pas on dirait bien. Probablement à cause de ircgline.Api_init() qui ne se termine pas
-		})
+	c.HandleFunc(irc.DISCONNECTED, handleDisconnect)
 	c.HandleFunc(irc.PRIVMSG, handlePRIVMSG)
 	c.HandleFunc(irc.NOTICE, handleNOTICE)
 	c.HandleFunc(irc.JOIN, handleJOIN)
 	c.HandleFunc(irc.QUIT, handleQUIT)
+	c.HandleFunc(irc.NICK, handleNICK)
+	c.HandleFunc("PONG", handlePONG)
 
 	c.HandleFunc("001", handle001)
 	c.HandleFunc("280", handleGline280)
 	c.HandleFunc("401", handle401NoSuchNick)
+	c.HandleFunc("311", handleWhoisUser)
+	c.HandleFunc("313", handleWhoisOperator)
+	c.HandleFunc("318", handleEndOfWhois)
+	c.HandleFunc("330", handleWhoisAccount)
 
 	// Tell client to connect.
 	//if err := c.Connect(); err != nil {
@@ -124,49 +195,7 @@ func Irc_init(config *Configuration) *serverData {
 
 func handlePRIVMSG(conn *irc.Conn, tline *irc.Line) {
 	s := servers.GetServerInfos(conn)
-	line := strings.Trim(tline.Raw, "\n")
-	line = strings.Trim(line, "\r")
-	w := strings.Fields(line)
-	if len(w) < 4 {
-		return
-	}
-	if w[2][0] == '#' && strings.EqualFold(w[3], ":!die") {
-		s.die()
-	}
-	if w[2][0] == '#' && strings.EqualFold(w[3], ":!g") {
-		if len(w) < 5 {
-			str := fmt.Sprintf("PRIVMSG %s :Syntax: !g <IP>", w[2])
-			s.Conn.Raw(str)
-			return
-		}
-		var entries []*glineData
-		var err error
-		if IsGlineIDFormat(w[4]) {
-			entries, err = s.CheckGlineByID(w[4])
-		} else {
-			active, inactive, cgErr := s.CheckGline(w[4], false)
-			entries, err = append(active, inactive...), cgErr
-		}
-		if err == nil {
-			str_slices := make([]string, 0, len(entries))
-			for _, entry := range entries {
-				tmpStr := formatGlineLine(entry)
-				str_slices = append(str_slices, tmpStr)
-				s.Conn.Raw(tmpStr)
-			}
-			if len(str_slices) > 0 {
-				//ret := strings.Join(str_slices, ",  ")
-				//s.Msg(w[2], ret)
-				for i, res := range str_slices {
-					ret := fmt.Sprintf("(%d/%d) %s", i+1, len(str_slices), res)
-					s.Conn.Privmsg(w[2], ret)
-				}
-			} else {
-				ret := fmt.Sprintf("No match: %s", w[4])
-				s.Conn.Privmsg(w[2], ret)
-			}
-		}
-	}
+	s.dispatchBotCommand(newInvocation(tline))
 }
 
 func formatGlineLine(entry *glineData) string {
@@ -177,64 +206,35 @@ func formatGlineLine(entry *glineData) string {
 	return fmt.Sprintf("EXPIRED: %s (expired <%d hours ago, lastmod %d hours ago): %s", mask, -entry.HoursUntilExpiration()+1, entry.HoursSinceLastMod(), entry.reason)
 }
 
-func (s *serverData) Connect() {
-	s.LoggedInToOperServ = false
-	for {
-		if err := s.Conn.Connect(); err != nil {
-			log.Printf("Connection error: %s\nTrying again in %d seconds\n", err.Error(), s.Config.ReconnWaitTime)
-			time.Sleep(time.Duration(s.Config.ReconnWaitTime) * time.Second)
-		} else {
-			go s.TimerPing()
-			break
-		}
-	}
-}
-
-func (s *serverData) TimerPing() {
-	for {
-		// Code to execute every 5 minutes
-		//fmt.Println("Sending PING", time.Now())
-		if s.Conn.Connected() {
-			s.Conn.Raw("PING :me")
-		} else {
-			debugLog("Not sending PING (Disconnected)")
-			//return
-		}
-		time.Sleep(60 * time.Second)
-	}
-}
-
-func (s *serverData) MsgMainChan(msg string) {
-	if !s.Conn.Connected() {
-		return
-	}
-	firstchannel := strings.Split(s.Config.Channels[0], " ")[0]
-	/*
-		// Use built-in Privmsg() instead: it splits long messages
-		str := fmt.Sprintf("PRIVMSG %s :%s", firstchannel, msg)
-		log.Println("->", str)
-		s.Conn.Raw(str)
-	*/
-	if len(firstchannel) > 0 {
-		s.Conn.Privmsg(firstchannel, msg)
+// mainChannel returns the first configured channel, without its key.
+func (s *serverData) mainChannel() string {
+	if len(s.Config.Channels) == 0 {
+		return ""
 	}
+	return strings.Split(s.Config.Channels[0], " ")[0]
 }
 
 func handleConnect(conn *irc.Conn, line *irc.Line) {
 	var cfg *Configuration
 	s := servers.GetServerInfos(conn)
 	cfg = s.Config
-	s.LoggedInToOperServ = true
+	prev := s.ConnState.Status()
+	s.ConnState.connected()
+	s.startKeepalive()
 	for _, cmd := range cfg.ConnectCmds {
 		conn.Raw(cmd)
 	}
 	modeStr := fmt.Sprintf("mode %s +s +33280", conn.Me().Nick)
 	conn.Raw(modeStr)
-	conn.Raw(cfg.OperServLogin)
+	s.operServLogin()
 	for _, c := range cfg.Channels {
 		conn.Join(c)
 	}
 	conn.Raw("gline")
+	if prev.Attempts > 0 {
+		s.announce(announceConnection, fmt.Sprintf("Reconnected to %s after %d failed attempt(s), last error: %s",
+			line.Src, prev.Attempts, prev.LastError))
+	}
 }
 
 func handleGline280(conn *irc.Conn, line *irc.Line) {
@@ -270,7 +270,7 @@ func handleGline280(conn *irc.Conn, line *irc.Line) {
 		// cidr is valid
 		//s.Cranger.Insert(newGlineData(*ip_net, user, mask, expireTS, lastModTS, reason, active))
 		//s.AddNewGline(newGlineData(*ip_net, user, mask, expireTS, lastModTS, reason, active))
-		s.AddOrUpdateGline(*ip_net, user, mask, expireTS, lastModTS, reason, &active, line.Raw)
+		s.AddOrUpdateGline(*ip_net, user, mask, "", expireTS, lastModTS, reason, &active, line.Raw)
 	} else {
 		log.Println("Invalid IP/CIDR for mask:", mask)
 	}
@@ -283,8 +283,7 @@ func handleJOIN(conn *irc.Conn, line *irc.Line) {
 	w := strings.Split(line.Raw, " ")
 	nick := strings.Split(w[0][1:], "!")[0]
 	if nick == s.Config.OperServNick {
-		s.Conn.Raw(s.Config.OperServLogin)
-		s.LoggedInToOperServ = true
+		s.operServLogin()
 	}
 	handleGNOTICE(line.Raw, w, s)
 }
@@ -295,14 +294,24 @@ func handleQUIT(conn *irc.Conn, line *irc.Line) {
 	w := strings.Split(line.Raw, " ")
 	nick := strings.Split(w[0][1:], "!")[0]
 	if nick == s.Config.OperServNick {
-		s.LoggedInToOperServ = false
+		s.OperServ.reset()
 	}
+	s.Whois.Forget(nick)
 	handleGNOTICE(line.Raw, w, s)
 }
 
+func handleNICK(conn *irc.Conn, line *irc.Line) {
+	s := servers.GetServerInfos(conn)
+	s.Whois.Forget(line.Nick)
+}
+
 func handleNOTICE(conn *irc.Conn, line *irc.Line) {
 	debugLog(line.Raw)
 	s := servers.GetServerInfos(conn)
+	if s.Config.OperServNick != "" && strings.EqualFold(line.Nick, s.Config.OperServNick) {
+		s.handleOperServReply(line.Text())
+		return
+	}
 	w := strings.Split(line.Raw, " ")
 	handleGNOTICE(line.Raw, w, s)
 }
@@ -325,6 +334,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 	if w[2] != "*" {
 		return nil
 	}
+	setter := w[6]
 	if w[8] == "deactivated" && w[9] == "global" && w[10] == "GLINE" {
 		//<- :hidden.undernet.org NOTICE * :*** Notice -- gnu.undernet.org adding deactivated global GLINE for *@1.1.1.1, expiring at 1669690015: Unknown G-Line
 		*active = false
@@ -338,7 +348,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 			}
 		} else {
 			out := fmt.Sprintf("Parse error: %s", line)
-			s.MsgMainChan(out)
+			s.announce(announceParseError, out)
 			retErr = errors.New(out)
 		}
 	} else if w[8] != "global" && w[9] != "GLINE" {
@@ -356,7 +366,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 			reason = strings.Join(w[15:], " ")
 		} else {
 			out := fmt.Sprintf("Parse error: %s", line)
-			s.MsgMainChan(out)
+			s.announce(announceParseError, out)
 			retErr = errors.New(out)
 		}
 		debugLog(mask, expireTSstr)
@@ -371,7 +381,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 				*active = false
 			default:
 				out := fmt.Sprintf("Parse error: %s", line)
-				s.MsgMainChan(out)
+				s.announce(announceParseError, out)
 				retErr = errors.New(out)
 			}
 		}
@@ -425,7 +435,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 					expireTSstr = RemoveLastChar(expireTSstr)
 				} else {
 					out := fmt.Sprintf("Parse error: %s", line)
-					s.MsgMainChan(out)
+					s.announce(announceParseError, out)
 					retErr = errors.New(out)
 				}
 			} else if w[13] == "activating" && w[16] == "changing" {
@@ -437,7 +447,7 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 					expireTSstr = w[20]
 				} else {
 					out := fmt.Sprintf("Parse error: %s", line)
-					s.MsgMainChan(out)
+					s.announce(announceParseError, out)
 					retErr = errors.New(out)
 				}
 			} else if w[13] == "expiration" {
@@ -451,13 +461,13 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 					expireTSstr = RemoveLastChar(expireTSstr)
 				} else {
 					out := fmt.Sprintf("Parse error: %s", line)
-					s.MsgMainChan(out)
+					s.announce(announceParseError, out)
 					retErr = errors.New(out)
 				}
 				//TODO: send "GLINE <mask>" to server, as it is impossible from the message to know from this message if the gline is active or not. The expiration time will be in the future, even if the gline is being deactivated. I have to make sure that I also adapt handeGline280() to be able to update the info instead of just insert.
 			} else {
 				out := fmt.Sprintf("Uncaught gline message message: %s", line)
-				s.MsgMainChan(out)
+				s.announce(announceParseError, out)
 				retErr = errors.New(out)
 				return retErr
 			}
@@ -476,10 +486,10 @@ func handleGNOTICE(line string, w []string, s *serverData) error {
 	lastModTS = time.Now().Unix()
 	ip = AddCidrToIP(ip)
 	if _, ip_net, err := net.ParseCIDR(ip); err == nil {
-		s.AddOrUpdateGline(*ip_net, user, mask, expireTS, lastModTS, reason, active, line)
+		s.AddOrUpdateGline(*ip_net, user, mask, setter, expireTS, lastModTS, reason, active, line)
 	} else {
 		out := fmt.Sprintf("net.ParseCIDR(%s) error: %s", ip, line)
-		s.MsgMainChan(out)
+		s.announce(announceParseError, out)
 		retErr = errors.New(out)
 	}
 	return retErr
@@ -489,7 +499,7 @@ func handle401NoSuchNick(conn *irc.Conn, line *irc.Line) {
 	s := servers.GetServerInfos(conn)
 	log.Printf("No such nick/channel: %s\n", line.Args[1])
 	if line.Args[1] == s.Config.OperServNick {
-		s.LoggedInToOperServ = false
+		s.OperServ.reset()
 	}
 }
 
@@ -502,16 +512,22 @@ func handle001(conn *irc.Conn, line *irc.Line) {
 	}
 }
 
+// die disconnects for good and closes s.Quit, which stops the program.
 func (s *serverData) die() {
-	s.Conn.Raw("QUIT :Killed")
-	time.Sleep(1 * time.Second)
-	os.Exit(0)
+	s.ConnState.stop.Do(func() {
+		s.ConnState.set(connStateStopped, "", nil)
+		s.Conn.Raw("QUIT :Killed")
+		time.Sleep(1 * time.Second)
+		close(s.Quit)
+	})
 }
 
 func (s *serverData) sendCommandToOperServ(cmd string) {
-	if s.Config.AutologinIfOperServMissing && !s.LoggedInToOperServ && (time.Now().Unix()-s.LastLoginAttempt) > 120 {
-		s.LastLoginAttempt = time.Now().Unix()
-		s.Conn.Raw(s.Config.OperServLogin)
+	send, login := s.OperServ.submit(cmd, s.Config.AutologinIfOperServMissing, time.Now().Unix())
+	if login {
+		s.operServLogin()
+	}
+	if send {
+		s.sendToOperServ([]string{cmd})
 	}
-	s.Conn.Privmsg(s.Config.OperServNick, cmd)
 }
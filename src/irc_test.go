@@ -266,3 +266,17 @@ func TestHandleGNOTICEGlineID(t *testing.T) {
 		t.Fatalf("CheckGlineByID(D-B-1) after modify 2 = %+v, want 1 entry with expireTS 1810000000", newRes2)
 	}
 }
+
+// newTestServer registers a disconnected serverData for config, the same
+// way Irc_init does, so handlers can be fed raw lines.
+func newTestServer(config *Configuration) *serverData {
+	irccfg := irc.NewConfig(config.Nick)
+	irccfg.SSL = false
+	irccfg.Server = config.Server
+	irccfg.Me.Ident = config.Ident
+	irccfg.Me.Name = config.Name
+	ircClient := irc.Client(irccfg)
+	s := servers.NewServerInfos(ircClient, config)
+	s.ServerName = config.Server
+	return s
+}
@@ -0,0 +1,248 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// KeepaliveConfig controls the pings sent to the server to measure lag and
+// detect dead connections.
+type KeepaliveConfig struct {
+	// Seconds between pings. Defaults to 60.
+	IntervalSeconds int
+	// The connection is dropped, and a new one attempted, when no PONG
+	// was received for that many seconds. Defaults to 180.
+	TimeoutSeconds int
+}
+
+func (c KeepaliveConfig) interval() time.Duration {
+	if c.IntervalSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.IntervalSeconds) * time.Second
+}
+
+func (c KeepaliveConfig) timeout() time.Duration {
+	if c.TimeoutSeconds <= 0 {
+		return 180 * time.Second
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// keepalive pings the server while connected and measures the lag from
+// the PONG replies. The loop is started on each connection and stopped
+// when it is lost.
+type keepalive struct {
+	mu       sync.Mutex
+	stop     chan struct{} // closed to stop the running loop
+	lastPong time.Time
+	lag      time.Duration // of the latest PONG
+	avgLag   time.Duration // moving average
+	timeouts atomic.Uint64
+}
+
+// lagInfo is the lag part of /api2/health.
+type lagInfo struct {
+	LagMS    int64 `json:"lagms"`
+	AvgLagMS int64 `json:"avglagms"`
+	LastPong int64 `json:"lastpong,omitempty"`
+}
+
+func (k *keepalive) Lag() lagInfo {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	info := lagInfo{LagMS: k.lag.Milliseconds(), AvgLagMS: k.avgLag.Milliseconds()}
+	if !k.lastPong.IsZero() {
+		info.LastPong = k.lastPong.Unix()
+	}
+	return info
+}
+
+// Timeouts returns the number of connections dropped for lack of PONG.
+func (k *keepalive) Timeouts() uint64 {
+	return k.timeouts.Load()
+}
+
+// pong records a PONG received at time now. token is the text of the PING
+// it answers: a send time in nanoseconds, as sent by the loop, or anything
+// else, which proves the connection is alive but doesn't measure lag.
+func (k *keepalive) pong(token string, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.lastPong = now
+	sent, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return
+	}
+	lag := now.Sub(time.Unix(0, sent))
+	if lag < 0 || lag > time.Hour {
+		return
+	}
+	k.lag = lag
+	if k.avgLag == 0 {
+		k.avgLag = lag
+	} else {
+		k.avgLag = (k.avgLag*4 + lag) / 5
+	}
+}
+
+// timedOut reports whether no PONG was received within timeout of now.
+func (k *keepalive) timedOut(now time.Time, timeout time.Duration) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return now.Sub(k.lastPong) > timeout
+}
+
+// startKeepalive starts pinging on a new connection, stopping the loop of
+// the previous one if it is still running.
+func (s *serverData) startKeepalive() {
+	k := s.Keepalive
+	k.mu.Lock()
+	if k.stop != nil {
+		close(k.stop)
+	}
+	stop := make(chan struct{})
+	k.stop = stop
+	k.lastPong = time.Now()
+	k.mu.Unlock()
+	go s.keepaliveLoop(stop)
+}
+
+func (k *keepalive) stopLoop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+func (s *serverData) keepaliveLoop(stop chan struct{}) {
+	ticker := time.NewTicker(s.Config.Keepalive.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if !s.keepaliveTick(now) {
+				return
+			}
+		}
+	}
+}
+
+// keepaliveTick pings the server, unless it stopped answering, in which
+// case the connection is closed and false is returned. connectLoop then
+// reconnects.
+func (s *serverData) keepaliveTick(now time.Time) bool {
+	timeout := s.Config.Keepalive.timeout()
+	if s.Keepalive.timedOut(now, timeout) {
+		s.Keepalive.timeouts.Add(1)
+		log.Printf("No PONG from %s for %s, reconnecting\n", s.ServerName, timeout)
+		s.Conn.Close()
+		return false
+	}
+	if s.Conn.Connected() {
+		s.Conn.Ping(strconv.FormatInt(now.UnixNano(), 10))
+	}
+	return true
+}
+
+func handlePONG(conn *irc.Conn, line *irc.Line) {
+	s := servers.GetServerInfos(conn)
+	s.Keepalive.pong(line.Text(), time.Now())
+}
+
+// healthStatus is returned by /api2/health.
+type healthStatus = client.Health
+
+func (s *serverData) health() healthStatus {
+	st := s.ConnState.Status()
+	lag := s.Keepalive.Lag()
+	return healthStatus{
+		Network:  s.Config.Network,
+		Healthy:  st.State == connStateConnected,
+		State:    st.State,
+		Server:   st.Server,
+		OperServ: s.OperServ.Status().State,
+		LagMS:    lag.LagMS,
+		AvgLagMS: lag.AvgLagMS,
+		LastPong: lag.LastPong,
+	}
+}
+
+// sorted returns every network, sorted by name.
+func (s serversType) sorted() []*serverData {
+	list := make([]*serverData, 0, len(s))
+	for _, srv := range s {
+		list = append(list, srv)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Config.Network < list[j].Config.Network })
+	return list
+}
+
+// healthApi returns the health of every network, with 503 if any of them
+// isn't connected.
+func (a *ApiData) healthApi(c echo.Context) error {
+	list := make([]healthStatus, 0, len(servers))
+	code := http.StatusOK
+	for _, s := range servers.sorted() {
+		h := s.health()
+		if !h.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		list = append(list, h)
+	}
+	return c.JSON(code, list)
+}
+
+// metricsApi serves metrics in the Prometheus text format.
+func (a *ApiData) metricsApi(c echo.Context) error {
+	var b strings.Builder
+	metric := func(name, typ, help string, value func(s *serverData) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range servers.sorted() {
+			fmt.Fprintf(&b, "%s{network=%q} %g\n", name, s.Config.Network, value(s))
+		}
+	}
+	metric("ircglines_connected", "gauge", "Whether the bot is connected to IRC.", func(s *serverData) float64 {
+		if s.ConnState.Status().State == connStateConnected {
+			return 1
+		}
+		return 0
+	})
+	metric("ircglines_operserv_authenticated", "gauge", "Whether the bot is logged in to OperServ.", func(s *serverData) float64 {
+		if s.OperServ.Status().State == operServAuthenticated {
+			return 1
+		}
+		return 0
+	})
+	metric("ircglines_lag_seconds", "gauge", "Round-trip time of the latest PING.", func(s *serverData) float64 {
+		return float64(s.Keepalive.Lag().LagMS) / 1000
+	})
+	metric("ircglines_lag_average_seconds", "gauge", "Moving average of the PING round-trip time.", func(s *serverData) float64 {
+		return float64(s.Keepalive.Lag().AvgLagMS) / 1000
+	})
+	metric("ircglines_ping_timeouts_total", "counter", "Connections dropped for lack of PONG.", func(s *serverData) float64 {
+		return float64(s.Keepalive.Timeouts())
+	})
+	metric("ircglines_reconnect_attempts", "gauge", "Failed connection attempts since the last successful one.", func(s *serverData) float64 {
+		return float64(s.ConnState.Status().Attempts)
+	})
+	metric("ircglines_glines_expired_total", "counter", "Glines that reached their expiration time.", func(s *serverData) float64 {
+		return float64(s.Expiry.Expired())
+	})
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
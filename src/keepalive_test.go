@@ -0,0 +1,98 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestKeepalivePong(t *testing.T) {
+	k := &keepalive{}
+	now := time.Now()
+	k.pong(strconv.FormatInt(now.Add(-100*time.Millisecond).UnixNano(), 10), now)
+	k.pong(strconv.FormatInt(now.Add(-600*time.Millisecond).UnixNano(), 10), now)
+	if got := k.Lag(); got.LagMS != 600 || got.AvgLagMS != 200 || got.LastPong != now.Unix() {
+		t.Errorf(`Lag() = %+v. Want lag 600ms, average 200ms`, got)
+	}
+	// A PONG to someone else's PING proves the connection is alive but
+	// says nothing of the lag.
+	later := now.Add(time.Minute)
+	k.pong("me", later)
+	if got := k.Lag(); got.LagMS != 600 || got.LastPong != later.Unix() {
+		t.Errorf(`Lag() = %+v. Want lag unchanged, lastpong updated`, got)
+	}
+	if k.timedOut(later.Add(time.Minute), 2*time.Minute) {
+		t.Errorf(`timedOut() = true one minute after a PONG. Want false`)
+	}
+	if !k.timedOut(later.Add(3*time.Minute), 2*time.Minute) {
+		t.Errorf(`timedOut() = false three minutes after a PONG. Want true`)
+	}
+}
+
+func TestKeepaliveTickTimeout(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "keepalivenet", Server: "hidden.undernet.org", Nick: "GLK1",
+		Keepalive: KeepaliveConfig{TimeoutSeconds: 120}})
+	s.Keepalive.lastPong = time.Now()
+	if !s.keepaliveTick(time.Now().Add(time.Minute)) {
+		t.Errorf(`keepaliveTick() = false before the timeout. Want true`)
+	}
+	if s.keepaliveTick(time.Now().Add(3 * time.Minute)) {
+		t.Errorf(`keepaliveTick() = true after the timeout. Want false`)
+	}
+	if got := s.Keepalive.Timeouts(); got != 1 {
+		t.Errorf(`Timeouts() = %d. Want 1`, got)
+	}
+}
+
+func TestHealthAndMetricsApi(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "healthnet", Server: "hidden.undernet.org", Nick: "GLK2"})
+	defer delete(servers, s.Conn)
+	now := time.Now()
+	s.Keepalive.pong(strconv.FormatInt(now.Add(-250*time.Millisecond).UnixNano(), 10), now)
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/health", a.healthApi)
+	e.GET("/api2/metrics", a.metricsApi)
+
+	health := func() (int, healthStatus) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api2/health", nil)
+		e.ServeHTTP(w, r)
+		var list []healthStatus
+		json.Unmarshal(w.Body.Bytes(), &list)
+		for _, h := range list {
+			if h.Network == "healthnet" {
+				return w.Code, h
+			}
+		}
+		t.Fatalf(`health = %s. Want an entry for healthnet`, w.Body.String())
+		return 0, healthStatus{}
+	}
+	if code, h := health(); code != http.StatusServiceUnavailable || h.Healthy || h.LagMS != 250 {
+		t.Errorf(`health = %d %+v. Want 503, unhealthy, lag 250ms`, code, h)
+	}
+	s.ConnState.connected()
+	if _, h := health(); !h.Healthy || h.State != connStateConnected {
+		t.Errorf(`health = %+v. Want healthy once connected`, h)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api2/metrics", nil)
+	e.ServeHTTP(w, r)
+	for _, want := range []string{
+		`ircglines_connected{network="healthnet"} 1`,
+		`ircglines_lag_seconds{network="healthnet"} 0.25`,
+		`ircglines_lag_average_seconds{network="healthnet"} 0.25`,
+		`ircglines_ping_timeouts_total{network="healthnet"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf(`metrics doesn't contain %q:\n%s`, want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ircglineapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// openAPIDocument generates the OpenAPI 3 document describing api3Routes.
+func openAPIDocument() map[string]any {
+	paths := make(map[string]any)
+	for _, r := range api3Routes {
+		path := reEchoParam.ReplaceAllString(r.Path, "{$1}")
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(r.Method)] = openAPIOperation(r)
+	}
+	paths[api3OpenAPIPath] = map[string]any{
+		"get": map[string]any{
+			"summary":   "This document",
+			"responses": map[string]any{"200": map[string]any{"description": "OpenAPI document"}},
+		},
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "irc-glines-api",
+			"version": "3",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]any{
+				"Error": openAPISchema(reflect.TypeOf(api3Error{})),
+				"Meta":  openAPISchema(reflect.TypeOf(api3Meta{})),
+			},
+		},
+	}
+}
+
+var reEchoParam = regexp.MustCompile(`:(\w+)`)
+
+func openAPIOperation(r *api3Route) map[string]any {
+	params := make([]any, 0, len(r.Params))
+	for _, p := range r.Params {
+		params = append(params, map[string]any{
+			"name":        p.Name,
+			"in":          p.In,
+			"required":    p.In == "path",
+			"description": p.Description,
+			"schema":      map[string]any{"type": p.Type},
+		})
+	}
+	envelope := openAPIEnvelope(r.Data)
+	errorResponse := map[string]any{
+		"description": "Error. See error.code",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": envelope},
+		},
+	}
+	op := map[string]any{
+		"summary":    r.Summary,
+		"parameters": params,
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": http.StatusText(http.StatusOK),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": envelope},
+				},
+			},
+			"default": errorResponse,
+		},
+	}
+	if r.Accepted != nil {
+		op["responses"].(map[string]any)["202"] = map[string]any{
+			"description": http.StatusText(http.StatusAccepted),
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": openAPIEnvelope(r.Accepted)},
+			},
+		}
+	}
+	if r.Body != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": openAPISchema(reflect.TypeOf(r.Body))},
+			},
+		}
+	}
+	if !r.Open {
+		op["security"] = []any{map[string]any{"apiKey": []any{}}}
+	}
+	return op
+}
+
+// openAPIEnvelope describes a response whose data is like sample.
+func openAPIEnvelope(sample any) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"data":  openAPISchema(reflect.TypeOf(sample)),
+			"error": map[string]any{"$ref": "#/components/schemas/Error", "nullable": true},
+			"meta":  map[string]any{"$ref": "#/components/schemas/Meta"},
+		},
+	}
+}
+
+// openAPISchema describes t, following encoding/json's rules for struct
+// fields.
+func openAPISchema(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	var schema map[string]any
+	switch t.Kind() {
+	case reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case reflect.String:
+		schema = map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		schema = map[string]any{"type": "array", "items": openAPISchema(t.Elem())}
+	case reflect.Map:
+		schema = map[string]any{"type": "object", "additionalProperties": openAPISchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = openAPISchema(f.Type)
+		}
+		schema = map[string]any{"type": "object", "properties": props}
+	default:
+		schema = map[string]any{}
+	}
+	if nullable {
+		schema["nullable"] = true
+	}
+	return schema
+}
@@ -0,0 +1,204 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hiddn/irc-glines-api/client"
+)
+
+// OperServ login states, as shown by /api2/status.
+const (
+	operServUnknown       = "unknown"       // not logged in, nor trying to
+	operServPending       = "pending"       // login sent, waiting for the reply
+	operServAuthenticated = "authenticated" // a reply matched AuthSuccessfullMsgs
+	operServFailed        = "failed"        // a reply matched AuthFailureMsgs, or none came
+)
+
+// operServStatus is the state of the login to OperServ.
+type operServStatus = client.OperServStatus
+
+// operServAuth tracks the login to OperServ. Commands sent while the login
+// is pending are queued, then sent once it succeeds or dropped if it fails.
+type operServAuth struct {
+	mu          sync.Mutex
+	status      operServStatus
+	queue       []string
+	lastAttempt int64
+	attempt     int // incremented at each login, to recognize stale timeouts
+	success     []*regexp.Regexp
+	failure     []*regexp.Regexp
+}
+
+func newOperServAuth(cfg *Configuration) *operServAuth {
+	a := &operServAuth{status: operServStatus{State: operServUnknown, Since: time.Now().Unix()}}
+	for _, p := range cfg.AuthSuccessfullMsgs {
+		a.success = append(a.success, regexp.MustCompile(p))
+	}
+	for _, p := range cfg.AuthFailureMsgs {
+		a.failure = append(a.failure, regexp.MustCompile(p))
+	}
+	return a
+}
+
+func (a *operServAuth) Status() operServStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.status
+	st.Queued = len(a.queue)
+	return st
+}
+
+// setState must be called with a.mu held.
+func (a *operServAuth) setState(state string) {
+	if a.status.State != state {
+		a.status.Since = time.Now().Unix()
+	}
+	a.status.State = state
+}
+
+// begin records a login attempt and returns its number. Without success
+// patterns to recognize the reply, the login is assumed to succeed and the
+// queued commands are returned.
+func (a *operServAuth) begin(now int64) (int, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attempt++
+	a.lastAttempt = now
+	if len(a.success) == 0 {
+		a.setState(operServAuthenticated)
+		queue := a.queue
+		a.queue = nil
+		return a.attempt, queue
+	}
+	a.setState(operServPending)
+	return a.attempt, nil
+}
+
+// reply matches a message from OperServ against the success and failure
+// patterns. It returns the new state if the message matched, and the
+// commands to send or that were dropped.
+func (a *operServAuth) reply(msg string) (state string, queue []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case matchAny(a.success, msg):
+		state = operServAuthenticated
+	case a.status.State == operServPending && matchAny(a.failure, msg):
+		state = operServFailed
+	default:
+		return "", nil
+	}
+	a.setState(state)
+	a.status.LastReply = msg
+	queue = a.queue
+	a.queue = nil
+	return state, queue
+}
+
+// timeout fails the login attempt if it is still pending, and returns the
+// dropped commands.
+func (a *operServAuth) timeout(attempt int) (bool, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if attempt != a.attempt || a.status.State != operServPending {
+		return false, nil
+	}
+	a.setState(operServFailed)
+	a.status.LastReply = ""
+	queue := a.queue
+	a.queue = nil
+	return true, queue
+}
+
+// reset forgets the login, when the connection or OperServ is lost.
+// Queued commands are kept for the next login.
+func (a *operServAuth) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attempt++
+	a.setState(operServUnknown)
+}
+
+// submit decides what to do with a command: send it now, queue it, or
+// queue it and log in first.
+func (a *operServAuth) submit(cmd string, autologin bool, now int64) (send, login bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch a.status.State {
+	case operServAuthenticated:
+		return true, false
+	case operServPending:
+		a.queue = append(a.queue, cmd)
+		return false, false
+	}
+	if autologin && now-a.lastAttempt > 120 {
+		a.queue = append(a.queue, cmd)
+		return false, true
+	}
+	return true, false
+}
+
+func matchAny(list []*regexp.Regexp, msg string) bool {
+	for _, re := range list {
+		if re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// operServLogin sends the login to OperServ and waits for its reply.
+func (s *serverData) operServLogin() {
+	attempt, queue := s.OperServ.begin(time.Now().Unix())
+	s.rawIfConnected(s.Config.OperServLogin)
+	s.sendToOperServ(queue)
+	time.AfterFunc(s.Config.authTimeout(), func() {
+		if failed, dropped := s.OperServ.timeout(attempt); failed {
+			s.operServLoginFailed("no reply", dropped)
+		}
+	})
+}
+
+// handleOperServReply is called with every message from OperServ.
+func (s *serverData) handleOperServReply(msg string) {
+	state, queue := s.OperServ.reply(msg)
+	switch state {
+	case operServAuthenticated:
+		debugLogf("Logged in to %s: %s\n", s.Config.OperServNick, msg)
+		s.sendToOperServ(queue)
+	case operServFailed:
+		s.operServLoginFailed(msg, queue)
+	}
+}
+
+func (s *serverData) operServLoginFailed(reason string, dropped []string) {
+	out := fmt.Sprintf("Login to %s failed: %s", s.Config.OperServNick, reason)
+	if len(dropped) > 0 {
+		out += fmt.Sprintf(". Dropped %d queued commands", len(dropped))
+	}
+	log.Println(out)
+	s.announce(announceConnection, out)
+}
+
+func (s *serverData) sendToOperServ(cmds []string) {
+	for _, cmd := range cmds {
+		s.rawIfConnected(fmt.Sprintf("PRIVMSG %s :%s", s.Config.OperServNick, cmd))
+	}
+}
+
+func (s *serverData) rawIfConnected(line string) {
+	if s.Conn.Connected() {
+		s.Conn.Raw(line)
+	}
+}
+
+func (cfg *Configuration) authTimeout() time.Duration {
+	if cfg.AuthTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(cfg.AuthTimeout) * time.Second
+}
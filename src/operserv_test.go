@@ -0,0 +1,67 @@
+package ircglineapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOperServAuth(t *testing.T) {
+	a := newOperServAuth(&Configuration{
+		AuthSuccessfullMsgs: []string{".*Authentication successful.*", ".*already authenticated.*"},
+		AuthFailureMsgs:     []string{".*Authentication failed.*"},
+	})
+	now := time.Now().Unix()
+	if send, login := a.submit("removegline *@10.0.0.1", false, now); !send || login {
+		t.Errorf(`submit() without autologin = %t, %t. Want sent as is`, send, login)
+	}
+	if send, login := a.submit("removegline *@10.0.0.2", true, now); send || !login {
+		t.Errorf(`submit() with autologin = %t, %t. Want queued, login`, send, login)
+	}
+	attempt, _ := a.begin(now)
+	if send, login := a.submit("removegline *@10.0.0.3", true, now); send || login {
+		t.Errorf(`submit() while pending = %t, %t. Want queued`, send, login)
+	}
+	if st := a.Status(); st.State != operServPending || st.Queued != 2 {
+		t.Errorf(`Status() = %+v. Want pending, 2 queued`, st)
+	}
+	if state, _ := a.reply("Unrelated notice"); state != "" {
+		t.Errorf(`reply(unrelated) = %q. Want no change`, state)
+	}
+	state, queue := a.reply("Authentication successful, welcome")
+	if state != operServAuthenticated || len(queue) != 2 || queue[0] != "removegline *@10.0.0.2" {
+		t.Errorf(`reply(success) = %q, %q. Want authenticated, the 2 queued commands`, state, queue)
+	}
+	if failed, _ := a.timeout(attempt); failed {
+		t.Errorf(`timeout() after success = true. Want false`)
+	}
+	if send, _ := a.submit("removegline *@10.0.0.4", true, now); !send {
+		t.Errorf(`submit() once authenticated didn't send`)
+	}
+
+	// A failure reply, then a timeout.
+	a.reset()
+	a.begin(now + 200)
+	a.submit("removegline *@10.0.0.5", true, now+200)
+	if state, queue := a.reply("Authentication failed for user"); state != operServFailed || len(queue) != 1 {
+		t.Errorf(`reply(failure) = %q, %q. Want failed, 1 dropped`, state, queue)
+	}
+	if send, login := a.submit("removegline *@10.0.0.6", true, now+250); !send || login {
+		t.Errorf(`submit() right after a failed login = %t, %t. Want sent as is`, send, login)
+	}
+	attempt, _ = a.begin(now + 400)
+	if failed, _ := a.timeout(attempt - 1); failed {
+		t.Errorf(`timeout() of a previous attempt = true. Want false`)
+	}
+	if failed, _ := a.timeout(attempt); !failed || a.Status().State != operServFailed {
+		t.Errorf(`timeout() = %t, state %s. Want failed`, failed, a.Status().State)
+	}
+}
+
+func TestOperServAuthWithoutPatterns(t *testing.T) {
+	a := newOperServAuth(&Configuration{})
+	now := time.Now().Unix()
+	a.submit("removegline *@10.0.0.1", true, now)
+	if _, queue := a.begin(now); len(queue) != 1 || a.Status().State != operServAuthenticated {
+		t.Errorf(`begin() = %q, state %s. Want authenticated at once`, queue, a.Status().State)
+	}
+}
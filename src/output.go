@@ -0,0 +1,199 @@
+package ircglineapi
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputConfig limits what the bot sends, so that a big lookup can't get it
+// killed for flooding.
+type OutputConfig struct {
+	// Maximum number of lines in a reply to a command. Longer results are
+	// paginated. Defaults to 5.
+	MaxLines int
+	// Sustained rate at which queued lines are sent, all targets
+	// included. Defaults to 1.
+	LinesPerSecond float64
+	// Number of lines that may be sent back to back after a quiet period.
+	// Defaults to 5.
+	Burst int
+	// Lines queued for a single target beyond this are dropped.
+	// Defaults to 50.
+	QueueLen int
+}
+
+func (c OutputConfig) maxLines() int {
+	if c.MaxLines <= 0 {
+		return 5
+	}
+	return c.MaxLines
+}
+
+func (c OutputConfig) linesPerSecond() float64 {
+	if c.LinesPerSecond <= 0 {
+		return 1
+	}
+	return c.LinesPerSecond
+}
+
+func (c OutputConfig) burst() int {
+	if c.Burst <= 0 {
+		return 5
+	}
+	return c.Burst
+}
+
+func (c OutputConfig) queueLen() int {
+	if c.QueueLen <= 0 {
+		return 50
+	}
+	return c.QueueLen
+}
+
+// outputQueue holds one queue of pending lines per target and sends them
+// round-robin, so one busy channel can't starve a private query. Sending
+// is rate limited with a token bucket.
+type outputQueue struct {
+	mu      sync.Mutex
+	cfg     OutputConfig
+	queues  map[string][]outputLine
+	order   []string // targets with pending lines, in round-robin order
+	wake    chan struct{}
+	start   sync.Once
+	send    func(target, msg string)
+	tokens  float64
+	lastTok time.Time
+}
+
+type outputLine struct {
+	target string
+	msg    string
+}
+
+func newOutputQueue(cfg OutputConfig, send func(target, msg string)) *outputQueue {
+	return &outputQueue{
+		cfg:     cfg,
+		queues:  make(map[string][]outputLine),
+		wake:    make(chan struct{}, 1),
+		send:    send,
+		tokens:  float64(cfg.burst()),
+		lastTok: time.Now(),
+	}
+}
+
+// Enqueue queues lines for target and returns how many had to be dropped
+// because the target's queue was full.
+func (q *outputQueue) Enqueue(target string, lines ...string) int {
+	q.start.Do(func() { go q.run() })
+	key := strings.ToLower(target)
+	q.mu.Lock()
+	pending, ok := q.queues[key]
+	if !ok || len(pending) == 0 {
+		q.order = append(q.order, key)
+	}
+	dropped := 0
+	for _, l := range lines {
+		if len(pending) >= q.cfg.queueLen() {
+			dropped++
+			continue
+		}
+		pending = append(pending, outputLine{target: target, msg: l})
+	}
+	q.queues[key] = pending
+	q.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("Output queue for %s is full: dropped %d lines\n", target, dropped)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// next pops the next line to send, round-robin across targets.
+func (q *outputQueue) next() (target, msg string, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return "", "", false
+	}
+	key := q.order[0]
+	q.order = q.order[1:]
+	pending := q.queues[key]
+	if len(pending) == 0 {
+		delete(q.queues, key)
+		return "", "", false
+	}
+	line := pending[0]
+	if len(pending) > 1 {
+		q.queues[key] = pending[1:]
+		q.order = append(q.order, key)
+	} else {
+		delete(q.queues, key)
+	}
+	return line.target, line.msg, true
+}
+
+// waitToken blocks until the rate limit allows one more line.
+func (q *outputQueue) waitToken() {
+	rate := q.cfg.linesPerSecond()
+	for {
+		now := time.Now()
+		q.tokens += now.Sub(q.lastTok).Seconds() * rate
+		if max := float64(q.cfg.burst()); q.tokens > max {
+			q.tokens = max
+		}
+		q.lastTok = now
+		if q.tokens >= 1 {
+			q.tokens--
+			return
+		}
+		time.Sleep(time.Duration((1 - q.tokens) / rate * float64(time.Second)))
+	}
+}
+
+func (q *outputQueue) run() {
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			empty := len(q.order) == 0
+			q.mu.Unlock()
+			if empty {
+				break
+			}
+			q.waitToken()
+			if target, msg, ok := q.next(); ok {
+				q.send(target, msg)
+			}
+		}
+	}
+}
+
+// paginate returns the lines of page (1-based) and how many lines come
+// after it. A result that fits in max lines is a single page. Otherwise
+// each page holds max-1 lines, leaving room for the "more" hint.
+func paginate(lines []string, page, max int) ([]string, int) {
+	if len(lines) <= max {
+		if page > 1 {
+			return nil, 0
+		}
+		return lines, 0
+	}
+	size := max - 1
+	if size < 1 {
+		size = 1
+	}
+	// Checked before multiplying, as page comes from the user.
+	if page > (len(lines)+size-1)/size {
+		return nil, 0
+	}
+	start := (page - 1) * size
+	end := start + size
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return lines[start:end], len(lines) - end
+}
@@ -0,0 +1,89 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	lines := []string{"a", "b", "c", "d", "e", "f", "g"}
+	cases := []struct {
+		page, max int
+		want      []string
+		more      int
+	}{
+		{1, 10, lines, 0},
+		{2, 10, nil, 0},
+		{1, 3, []string{"a", "b"}, 5},
+		{2, 3, []string{"c", "d"}, 3},
+		{4, 3, []string{"g"}, 0},
+		{5, 3, nil, 0},
+		{math.MaxInt, 3, nil, 0},
+		{math.MaxInt, 10, nil, 0},
+	}
+	for _, c := range cases {
+		res, more := paginate(lines, c.page, c.max)
+		if fmt.Sprint(res) != fmt.Sprint(c.want) || more != c.more {
+			t.Errorf(`paginate(page=%d, max=%d) = %q, %d. Want %q, %d`, c.page, c.max, res, more, c.want, c.more)
+		}
+	}
+}
+
+func TestOutputQueueRoundRobin(t *testing.T) {
+	sent := make(chan string, 10)
+	q := newOutputQueue(OutputConfig{LinesPerSecond: 1000, Burst: 10}, func(target, msg string) {
+		sent <- target + " " + msg
+	})
+	q.start.Do(func() {}) // queue everything before the sender starts draining
+	q.Enqueue("#chan", "c1", "c2", "c3")
+	q.Enqueue("nick", "n1")
+	go q.run()
+	want := []string{"#chan c1", "nick n1", "#chan c2", "#chan c3"}
+	for i, w := range want {
+		select {
+		case got := <-sent:
+			if got != w {
+				t.Errorf(`line %d = %q. Want %q`, i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf(`timed out waiting for line %d`, i)
+		}
+	}
+}
+
+func TestOutputQueueDropsOverflow(t *testing.T) {
+	q := newOutputQueue(OutputConfig{QueueLen: 2}, func(target, msg string) {})
+	q.start.Do(func() {})
+	if dropped := q.Enqueue("#chan", "1", "2", "3", "4"); dropped != 2 {
+		t.Errorf(`Enqueue() dropped %d lines. Want 2`, dropped)
+	}
+}
+
+func TestPrivateQueryIsPaginated(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network: "undernet",
+		Server:  "hidden.undernet.org",
+		Nick:    "GLO1",
+		Output:  OutputConfig{MaxLines: 3, LinesPerSecond: 1000, Burst: 10},
+	})
+	sent := make(chan string, 10)
+	s.Out = newOutputQueue(s.Config.Output, func(target, msg string) { sent <- target + " " + msg })
+	now := time.Now().Unix()
+	for i := 1; i <= 7; i++ {
+		addTestGline(s, fmt.Sprintf("*@10.9.0.%d", i), now+3600, now, "test", true)
+	}
+	s.dispatchBotCommand(&invocation{Nick: "oper", Target: "GLO1", Text: "!g 10.9.0.0/16"})
+	want := []string{"oper (1/7)", "oper (2/7)", "oper 5 more, use !g 10.9.0.0/16 page 2"}
+	for i, w := range want {
+		select {
+		case got := <-sent:
+			if len(got) < len(w) || got[:len(w)] != w {
+				t.Errorf(`line %d = %q. Want prefix %q`, i, got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf(`timed out waiting for line %d`, i)
+		}
+	}
+}
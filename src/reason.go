@@ -0,0 +1,100 @@
+package ircglineapi
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// ReasonParserConfig holds the regexes used to pull structured fields out of
+// gline reasons. The first capture group of a pattern is the field's value.
+// Empty patterns use the defaults, which fit Undernet's reasons, e.g.
+// "AUTO [0] (74.102.24.245) You were identified as a drone. Email
+// abuse@undernet.org for removal. Visit https://www.undernet.org/... (P540)".
+// Set a pattern to "-" to disable the field.
+type ReasonParserConfig struct {
+	Auto   string // no capture group: the reason is automatic if it matches
+	Level  string
+	IP     string
+	Policy string
+	Email  string
+	URL    string
+}
+
+var defaultReasonPatterns = ReasonParserConfig{
+	Auto:   `^AUTO\b`,
+	Level:  `\[(\d+)\]`,
+	IP:     `\((\d{1,3}(?:\.\d{1,3}){3}|[0-9A-Fa-f]*:[0-9A-Fa-f:.]+)\)`,
+	Policy: `\((P\d+)\)`,
+	Email:  `([A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,})`,
+	URL:    `(https?://[^\s()]+)`,
+}
+
+// reasonInfo holds the fields parsed from a gline reason. The gline ID is
+// parsed separately by parseGlineID.
+type reasonInfo struct {
+	Auto   bool
+	Level  *int
+	IP     string
+	Policy string
+	Email  string
+	URL    string
+}
+
+type reasonParser struct {
+	auto, level, ip, policy, email, url *regexp.Regexp
+}
+
+func newReasonParser(cfg ReasonParserConfig) *reasonParser {
+	compile := func(name, pattern, def string) *regexp.Regexp {
+		switch pattern {
+		case "":
+			pattern = def
+		case "-":
+			return nil
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("Invalid reason parser pattern for %s: %s", name, err.Error())
+		}
+		return re
+	}
+	d := defaultReasonPatterns
+	return &reasonParser{
+		auto:   compile("auto", cfg.Auto, d.Auto),
+		level:  compile("level", cfg.Level, d.Level),
+		ip:     compile("ip", cfg.IP, d.IP),
+		policy: compile("policy", cfg.Policy, d.Policy),
+		email:  compile("email", cfg.Email, d.Email),
+		url:    compile("url", cfg.URL, d.URL),
+	}
+}
+
+// submatch returns the first capture group of re in s, or "".
+func submatch(re *regexp.Regexp, s string) string {
+	if re == nil {
+		return ""
+	}
+	m := re.FindStringSubmatch(s)
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
+
+func (p *reasonParser) Parse(reason string) reasonInfo {
+	info := reasonInfo{
+		Auto:   p.auto != nil && p.auto.MatchString(reason),
+		IP:     submatch(p.ip, reason),
+		Policy: submatch(p.policy, reason),
+		Email:  submatch(p.email, reason),
+		URL:    submatch(p.url, reason),
+	}
+	if level, err := strconv.Atoi(submatch(p.level, reason)); err == nil {
+		info.Level = &level
+	}
+	if !Is_valid_ip(info.IP) {
+		info.IP = ""
+	}
+	return info
+}
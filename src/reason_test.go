@@ -0,0 +1,62 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestReasonParserDefaults(t *testing.T) {
+	p := newReasonParser(ReasonParserConfig{})
+	reason := "AUTO [0] (74.102.24.245) You were identified as a drone. Email abuse@undernet.org for removal. See https://www.undernet.org/drones (P540) - ID: D1785006545-2001"
+	info := p.Parse(reason)
+	if !info.Auto || info.Level == nil || *info.Level != 0 || info.IP != "74.102.24.245" ||
+		info.Policy != "P540" || info.Email != "abuse@undernet.org" || info.URL != "https://www.undernet.org/drones" {
+		t.Errorf(`Parse(%q) = %+v`, reason, info)
+	}
+	info = p.Parse("spamming (manual)")
+	if info.Auto || info.Level != nil || info.IP != "" || info.Policy != "" || info.Email != "" || info.URL != "" {
+		t.Errorf(`Parse("spamming (manual)") = %+v. Want no fields`, info)
+	}
+}
+
+func TestReasonParserConfig(t *testing.T) {
+	p := newReasonParser(ReasonParserConfig{Auto: "-", Policy: `policy #(\d+)`})
+	info := p.Parse("AUTO [2] clones, see policy #12 (P540)")
+	if info.Auto || info.Policy != "12" || info.Level == nil || *info.Level != 2 {
+		t.Errorf(`Parse() = %+v. Want Auto=false, Policy=12, Level=2`, info)
+	}
+}
+
+func TestSearchApiFilters(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "searchnet", Server: "hidden.undernet.org", Nick: "GLS2"})
+	s.NetworkName = "searchnet"
+	addTestGline(s, "*@74.102.24.245", 4102444800, 1000, "AUTO [0] (74.102.24.245) drone. Email abuse@undernet.org (P540)", true)
+	addTestGline(s, "*@10.9.0.0/16", 4102444800, 1000, "AUTO [1] (10.9.8.7) proxy (P327)", true)
+	addTestGline(s, "*@10.10.0.1", 4102444800, 1000, "manual ban", true)
+
+	e := echo.New()
+	a := &ApiData{EchoInstance: e}
+	e.GET("/api2/search/:network", a.searchApi)
+	cases := map[string]int{
+		"auto=true":            2,
+		"auto=false":           1,
+		"level=1":              1,
+		"policy=p540":          1,
+		"ip=10.0.0.0/8":        1,
+		"email=*@undernet.org": 1,
+		"auto=true&limit=1":    1,
+	}
+	for query, want := range cases {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api2/search/searchnet?"+query, nil)
+		e.ServeHTTP(w, r)
+		var list []RetGlineData
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != want {
+			t.Errorf("search?%s = %d %s. Want %d glines", query, w.Code, w.Body.String(), want)
+		}
+	}
+}
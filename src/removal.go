@@ -0,0 +1,283 @@
+package ircglineapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoverConfig selects how glines are removed on a network.
+type RemoverConfig struct {
+	// "operserv" (default): a command sent to a services bot.
+	// "gline": a GLINE -mask sent by the bot, which must be an oper.
+	// "webhook": an HTTP POST to the network's services.
+	Type string
+	// operserv: the services bot, OperServNick by default. The login to
+	// OperServ is only awaited when it is OperServNick.
+	Nick string
+	// operserv: the command template, OperServRemglineCmd by default.
+	Command string
+	// webhook: the URL receiving {"network", "mask", "reason"} as JSON, and
+	// extra headers, such as Authorization.
+	URL     string
+	Headers map[string]string
+	// Seconds to wait for the gline to be deactivated on IRC. Defaults to 60.
+	ConfirmTimeout int
+}
+
+// GlineRemover asks for the removal of glines. Remove returns once the
+// removal is confirmed, which is when the deactivation of the gline is seen
+// on IRC, or with an error.
+type GlineRemover interface {
+	Remove(ctx context.Context, mask, reason string) error
+	// Lines returns the IRC lines Remove sends, for the audit log.
+	Lines(mask, reason string) []string
+	// String describes where removals are sent, for messages.
+	String() string
+}
+
+var errRemovalUnconfirmed = errors.New("deactivation not seen on IRC")
+
+func (s *serverData) newGlineRemover(cfg RemoverConfig) GlineRemover {
+	switch strings.ToLower(cfg.Type) {
+	case "", "operserv":
+		r := &operServRemover{s: s, nick: cfg.Nick, command: cfg.Command}
+		if r.nick == "" {
+			r.nick = s.Config.OperServNick
+		}
+		if r.command == "" {
+			r.command = s.Config.OperServRemglineCmd
+		}
+		return r
+	case "gline":
+		return &operGlineRemover{s: s}
+	case "webhook":
+		if cfg.URL == "" {
+			log.Fatalln("remover: webhook needs an url, network:", s.Config.Network)
+		}
+		return &webhookRemover{s: s, url: cfg.URL, headers: cfg.Headers, client: &http.Client{Timeout: 30 * time.Second}}
+	}
+	log.Fatalf("remover: unknown type %q, network: %s\n", cfg.Type, s.Config.Network)
+	return nil
+}
+
+// operServRemover sends a command template, which may reference
+// $glinemask and $reason, to a services bot.
+type operServRemover struct {
+	s       *serverData
+	nick    string
+	command string
+}
+
+func (r *operServRemover) String() string { return r.nick }
+
+func (r *operServRemover) cmd(mask, reason string) string {
+	cmd := strings.Replace(r.command, "$glinemask", mask, -1)
+	return strings.Replace(cmd, "$reason", reason, -1)
+}
+
+func (r *operServRemover) Lines(mask, reason string) []string {
+	return []string{fmt.Sprintf("PRIVMSG %s :%s", r.nick, r.cmd(mask, reason))}
+}
+
+func (r *operServRemover) Remove(ctx context.Context, mask, reason string) error {
+	wait, done := r.s.Removals.expect(mask)
+	defer done()
+	r.send(r.cmd(mask, reason))
+	return confirmRemoval(ctx, wait)
+}
+
+// send sends cmd to the services bot, once logged in if it is OperServ.
+func (r *operServRemover) send(cmd string) {
+	if strings.EqualFold(r.nick, r.s.Config.OperServNick) {
+		r.s.sendCommandToOperServ(cmd)
+	} else {
+		r.s.rawIfConnected(fmt.Sprintf("PRIVMSG %s :%s", r.nick, cmd))
+	}
+}
+
+// operGlineRemover deactivates glines with the oper GLINE command.
+type operGlineRemover struct {
+	s *serverData
+}
+
+func (r *operGlineRemover) String() string { return "oper GLINE" }
+
+func (r *operGlineRemover) Lines(mask, reason string) []string { return []string{"GLINE -" + mask} }
+
+func (r *operGlineRemover) Remove(ctx context.Context, mask, reason string) error {
+	if !r.s.Conn.Connected() {
+		return errors.New("not connected")
+	}
+	wait, done := r.s.Removals.expect(mask)
+	defer done()
+	r.s.Conn.Raw(r.Lines(mask, reason)[0])
+	return confirmRemoval(ctx, wait)
+}
+
+// webhookRemover asks the network's services to remove glines over HTTP.
+// Any status other than 2xx is a failure.
+type webhookRemover struct {
+	s       *serverData
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (r *webhookRemover) String() string { return "webhook" }
+
+func (r *webhookRemover) Lines(mask, reason string) []string { return nil }
+
+func (r *webhookRemover) Remove(ctx context.Context, mask, reason string) error {
+	wait, done := r.s.Removals.expect(mask)
+	defer done()
+	body, _ := json.Marshal(map[string]string{"network": r.s.Config.Network, "mask": mask, "reason": reason})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return confirmRemoval(ctx, wait)
+}
+
+func confirmRemoval(ctx context.Context, wait <-chan struct{}) error {
+	select {
+	case <-wait:
+		return nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return errRemovalUnconfirmed
+		}
+		return ctx.Err()
+	}
+}
+
+// removalWaiters wakes up removers when the gline they removed is seen
+// deactivated, or changed as they asked.
+type removalWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]*changeWaiter // by normalized mask
+}
+
+type changeWaiter struct {
+	ch    chan struct{}
+	match func(*glineChange) bool
+}
+
+func newRemovalWaiters() *removalWaiters {
+	return &removalWaiters{waiters: make(map[string][]*changeWaiter)}
+}
+
+func waiterKey(mask string) string {
+	if norm, _, ok := normalizeMask(mask); ok {
+		return norm
+	}
+	return strings.ToLower(mask)
+}
+
+// expect returns a channel closed once mask is deactivated, and a function
+// to call when no longer waiting.
+func (w *removalWaiters) expect(mask string) (<-chan struct{}, func()) {
+	return w.expectChange(mask, func(c *glineChange) bool {
+		return c.Kind != glineExpired && !c.Gline.IsGlineActive()
+	})
+}
+
+// expectChange returns a channel closed once a change of mask matches, and
+// a function to call when no longer waiting.
+func (w *removalWaiters) expectChange(mask string, match func(*glineChange) bool) (<-chan struct{}, func()) {
+	key := waiterKey(mask)
+	cw := &changeWaiter{ch: make(chan struct{}), match: match}
+	w.mu.Lock()
+	w.waiters[key] = append(w.waiters[key], cw)
+	w.mu.Unlock()
+	return cw.ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.drop(key, cw)
+	}
+}
+
+// drop must be called with w.mu held.
+func (w *removalWaiters) drop(key string, cw *changeWaiter) {
+	list := w.waiters[key]
+	for i, c := range list {
+		if c == cw {
+			w.waiters[key] = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(w.waiters[key]) == 0 {
+		delete(w.waiters, key)
+	}
+}
+
+// Notify is a gline observer waking up the waiters of matching changes.
+func (w *removalWaiters) Notify(c *glineChange) {
+	key := waiterKey(c.Gline.Mask())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, cw := range append([]*changeWaiter(nil), w.waiters[key]...) {
+		if cw.match(c) {
+			close(cw.ch)
+			w.drop(key, cw)
+		}
+	}
+}
+
+func (cfg RemoverConfig) confirmTimeout() time.Duration {
+	if cfg.ConfirmTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(cfg.ConfirmTimeout) * time.Second
+}
+
+// requestRemoval removes the gline on mask in the background, and
+// announces the outcome in the main channel.
+func (s *serverData) requestRemoval(mask, reason string) {
+	go s.confirmedRemoval(mask, reason)
+}
+
+// confirmedRemoval removes the gline on mask, waits for the confirmation
+// up to ConfirmTimeout, and announces the outcome in the main channel.
+func (s *serverData) confirmedRemoval(mask, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Remover.confirmTimeout())
+	defer cancel()
+	if err := s.Remover.Remove(ctx, mask, reason); err != nil {
+		log.Printf("Removal of %s via %s failed: %s\n", mask, s.Remover, err.Error())
+		s.announce(announceRemoval, fmt.Sprintf("Removal of %s via %s failed: %s", mask, s.Remover, err.Error()))
+		return err
+	}
+	debugLogf("serverData.confirmedRemoval(): removal of %s confirmed\n", mask)
+	s.announce(announceRemoval, fmt.Sprintf("Removal of %s confirmed.", mask))
+	return nil
+}
+
+// removalStatus is the HTTP status of an API removal that failed with err:
+// 504 if the gline wasn't seen deactivated in time, 502 otherwise.
+func removalStatus(err error) int {
+	if errors.Is(err, errRemovalUnconfirmed) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
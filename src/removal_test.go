@@ -0,0 +1,105 @@
+package ircglineapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewGlineRemover(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "removernet", Server: "hidden.undernet.org", Nick: "GLM1",
+		OperServNick: "euworld", OperServRemglineCmd: "removegline $glinemask"})
+	var tests = []struct {
+		cfg  RemoverConfig
+		want string
+	}{
+		{RemoverConfig{}, "euworld"},
+		{RemoverConfig{Type: "operserv", Nick: "GlineBot"}, "GlineBot"},
+		{RemoverConfig{Type: "gline"}, "oper GLINE"},
+		{RemoverConfig{Type: "webhook", URL: "http://127.0.0.1/remove"}, "webhook"},
+	}
+	for _, tt := range tests {
+		if got := s.newGlineRemover(tt.cfg).String(); got != tt.want {
+			t.Errorf(`newGlineRemover(%+v) = %s. Want %s`, tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookRemover(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "webhooknet", Server: "hidden.undernet.org", Nick: "GLM2"})
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.93.0.1", now+3600, now, "to remove", true)
+	fail := false
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if fail {
+			http.Error(w, "no such gline", http.StatusNotFound)
+			return
+		}
+		go func() {
+			// The services deactivate the gline, which the bot then sees.
+			time.Sleep(50 * time.Millisecond)
+			inactive := false
+			_, ipNet, _ := net.ParseCIDR("10.93.0.1/32")
+			s.AddOrUpdateGline(*ipNet, "*", "*@10.93.0.1", "services.undernet.org", now+3600, now, "to remove", &inactive, "")
+		}()
+	}))
+	defer srv.Close()
+	r := s.newGlineRemover(RemoverConfig{Type: "webhook", URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer secret"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.Remove(ctx, "*@10.93.0.1", "false positive"); err != nil {
+		t.Errorf(`Remove() = %v. Want confirmed`, err)
+	}
+	if got["network"] != "webhooknet" || got["mask"] != "*@10.93.0.1" || got["reason"] != "false positive" {
+		t.Errorf(`webhook received %q`, got)
+	}
+
+	fail = true
+	if err := r.Remove(ctx, "*@10.93.0.2", ""); err == nil {
+		t.Errorf(`Remove() with a 404 reply = nil. Want an error`)
+	}
+	fail = false
+	short, cancel2 := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel2()
+	if err := r.Remove(short, "*@10.93.0.3", ""); !errors.Is(err, errRemovalUnconfirmed) {
+		t.Errorf(`Remove() of a gline never deactivated = %v. Want %v`, err, errRemovalUnconfirmed)
+	}
+}
+
+func TestConfirmedRemoval(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "confirmnet", Server: "hidden.undernet.org", Nick: "GLM3",
+		Remover: RemoverConfig{ConfirmTimeout: 1}})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "no such gline", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	var tests = []struct {
+		url  string
+		code int
+	}{
+		{srv.URL + "/fail", http.StatusBadGateway},
+		// Accepted, but the gline is never seen deactivated.
+		{srv.URL + "/ok", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		s.Remover = s.newGlineRemover(RemoverConfig{Type: "webhook", URL: tt.url})
+		err := s.confirmedRemoval("*@10.93.1.1", "")
+		if err == nil || removalStatus(err) != tt.code {
+			t.Errorf(`confirmedRemoval() via %s = %v. Want an error with status %d`, tt.url, err, tt.code)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionConfig bounds how much gline history is kept in memory.
+// A zero value disables the matching limit, which keeps the old behaviour
+// of never forgetting anything.
+type RetentionConfig struct {
+	// Expired or deactivated glines are dropped once they have been
+	// inactive for that many days. Frozen ID snapshots are dropped once
+	// they have been superseded for that long, and the gline history
+	// forgets what happened before then.
+	InactiveDays int
+	// Maximum number of superseded IDs kept per gline mask.
+	MaxHistoricalIDsPerMask int
+	// How often the compactor runs. Defaults to 60 minutes.
+	CompactIntervalMinutes int
+}
+
+func (r RetentionConfig) Enabled() bool {
+	return r.InactiveDays > 0 || r.MaxHistoricalIDsPerMask > 0
+}
+
+func (r RetentionConfig) interval() time.Duration {
+	if r.CompactIntervalMinutes <= 0 {
+		return 60 * time.Minute
+	}
+	return time.Duration(r.CompactIntervalMinutes) * time.Minute
+}
+
+// CompactReport describes what a single compaction pass pruned.
+type CompactReport struct {
+	Glines  int // inactive glines removed from the trie
+	Nodes   int // emptied glinesData nodes removed from the ranger
+	IDs     int // superseded ID snapshots removed from GlinesByID
+	History int // gline versions removed from the history
+}
+
+func (r CompactReport) Empty() bool {
+	return r.Glines == 0 && r.Nodes == 0 && r.IDs == 0 && r.History == 0
+}
+
+func (r CompactReport) String() string {
+	return fmt.Sprintf("%d inactive glines, %d empty nodes, %d historical IDs, %d history versions", r.Glines, r.Nodes, r.IDs, r.History)
+}
+
+// allGlinesData returns every node of the ranger, IPv4 and IPv6.
+// The caller must hold s.mu.
+func (s *serverData) allGlinesData() []*glinesData {
+	list := make([]*glinesData, 0, s.Cranger.Len())
+	for _, cidr := range []string{"0.0.0.0/0", "::/0"} {
+		_, all, _ := net.ParseCIDR(cidr)
+		entries, err := s.Cranger.CoveredNetworks(*all)
+		if err != nil {
+			debugLogf("serverData.allGlinesData(): %s: %s\n", cidr, err.Error())
+			continue
+		}
+		for _, e := range entries {
+			if gd, ok := e.(*glinesData); ok {
+				list = append(list, gd)
+			}
+		}
+	}
+	return list
+}
+
+// inactiveSince returns the timestamp at which g stopped being active, or 0
+// if it is still active at time now.
+func (g *glineData) inactiveSince(now int64) int64 {
+	if g.active && g.expireTS > now {
+		return 0
+	}
+	since := g.expireTS
+	if since == 0 || (!g.active && g.lastModTS < since) {
+		since = g.lastModTS
+	}
+	return since
+}
+
+// supersededSince returns when a frozen snapshot was replaced by a newer ID.
+func (g *glineData) supersededSince() int64 {
+	if g.supersededTS != 0 {
+		return g.supersededTS
+	}
+	return g.lastModTS
+}
+
+// Compact applies the retention policy once, as of time now.
+// Inactive glines older than the retention window are removed, along with
+// the ranger nodes they leave empty. Frozen clones kept in GlinesByID after
+// an ID reassignment are aged out the same way and capped per mask.
+func (s *serverData) Compact(now int64) CompactReport {
+	var report CompactReport
+	cfg := s.Config.Retention
+	var cutoff int64
+	if cfg.InactiveDays > 0 {
+		cutoff = now - int64(cfg.InactiveDays)*86400
+	}
+
+	if cutoff > 0 {
+		report.History = s.History.Prune(cutoff)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := make(map[*glineData]bool)
+	for _, gd := range s.allGlinesData() {
+		kept := make([]*glineData, 0, len(gd.Glines))
+		for _, g := range gd.Glines {
+			if since := g.inactiveSince(now); cutoff > 0 && since != 0 && since < cutoff {
+				debugLogf("serverData.Compact(): pruning %s (inactive since %d)\n", g.Mask(), since)
+				if id := g.ID(); id != "" && s.GlinesByID[id] == g {
+					delete(s.GlinesByID, id)
+				}
+				report.Glines++
+				continue
+			}
+			kept = append(kept, g)
+			live[g] = true
+		}
+		gd.Glines = kept
+		if len(kept) == 0 {
+			if _, err := s.Cranger.Remove(gd.IpNet); err != nil {
+				log.Printf("serverData.Compact(): removing %s failed: %s\n", gd.NetworkStr(), err.Error())
+				continue
+			}
+			report.Nodes++
+		}
+	}
+
+	// Whatever GlinesByID points to that is no longer in the trie is a
+	// frozen snapshot of a superseded ID.
+	history := make(map[string][]string)
+	for id, g := range s.GlinesByID {
+		if live[g] {
+			continue
+		}
+		if cutoff > 0 && g.supersededSince() < cutoff {
+			delete(s.GlinesByID, id)
+			report.IDs++
+			continue
+		}
+		key := strings.ToLower(g.Mask())
+		history[key] = append(history[key], id)
+	}
+	if max := cfg.MaxHistoricalIDsPerMask; max > 0 {
+		for _, ids := range history {
+			if len(ids) <= max {
+				continue
+			}
+			sort.Slice(ids, func(i, j int) bool {
+				return s.GlinesByID[ids[i]].supersededSince() > s.GlinesByID[ids[j]].supersededSince()
+			})
+			for _, id := range ids[max:] {
+				delete(s.GlinesByID, id)
+				report.IDs++
+			}
+		}
+	}
+	return report
+}
+
+// compactLoop runs Compact at the configured interval and logs what was pruned.
+func (s *serverData) compactLoop() {
+	ticker := time.NewTicker(s.Config.Retention.interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		report := s.Compact(time.Now().Unix())
+		if !report.Empty() {
+			log.Printf("Compaction (%s): pruned %s\n", s.Config.Network, report)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ircglineapi
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func addTestGline(s *serverData, mask string, expireTS, lastModTS int64, reason string, active bool) {
+	_, ipNet, _ := net.ParseCIDR(AddCidrToIP(strings.SplitN(mask, "@", 2)[1]))
+	s.AddOrUpdateGline(*ipNet, "*", mask, "", expireTS, lastModTS, reason, &active, "")
+}
+
+func TestCompactPrunesInactiveGlines(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network:   "undernet",
+		Server:    "hidden.undernet.org",
+		Nick:      "GLR1",
+		Retention: RetentionConfig{InactiveDays: 7},
+	})
+	now := int64(2000000000)
+	addTestGline(s, "*@10.0.0.1", now-30*86400, now-40*86400, "old - ID: OLD-1", true)
+	addTestGline(s, "*@10.0.0.2", now+86400, now-40*86400, "deactivated long ago", false)
+	addTestGline(s, "*@10.0.0.3", now-86400, now-2*86400, "recently expired", true)
+	addTestGline(s, "*@10.0.0.4", now+86400, now-40*86400, "still active", true)
+
+	report := s.Compact(now)
+	if report.Glines != 2 || report.Nodes != 2 || report.IDs != 0 {
+		t.Fatalf("Compact() = %+v, want 2 glines, 2 nodes, 0 IDs", report)
+	}
+	if _, ok := s.GlinesByID["OLD-1"]; ok {
+		t.Errorf("GlinesByID still references a pruned gline")
+	}
+	for ip, want := range map[string]int{"10.0.0.1": 0, "10.0.0.2": 0, "10.0.0.3": 1, "10.0.0.4": 1} {
+		active, inactive, _ := s.CheckGline(ip, false)
+		if got := len(active) + len(inactive); got != want {
+			t.Errorf("CheckGline(%s) returned %d glines after Compact(), want %d", ip, got, want)
+		}
+	}
+}
+
+func TestCompactCapsHistoricalIDs(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network:   "undernet",
+		Server:    "hidden.undernet.org",
+		Nick:      "GLR2",
+		Retention: RetentionConfig{MaxHistoricalIDsPerMask: 1},
+	})
+	now := int64(2000000000)
+	addTestGline(s, "*@10.1.0.1", now+86400, now, "r - ID: H-1", true)
+	addTestGline(s, "*@10.1.0.1", now+86400, now, "r - ID: H-2", true)
+	addTestGline(s, "*@10.1.0.1", now+86400, now, "r - ID: H-3", true)
+	s.GlinesByID["H-1"].supersededTS = now - 10
+	s.GlinesByID["H-2"].supersededTS = now - 5
+
+	report := s.Compact(now)
+	if report.IDs != 1 || report.Glines != 0 {
+		t.Fatalf("Compact() = %+v, want 1 ID and 0 glines pruned", report)
+	}
+	if _, ok := s.GlinesByID["H-1"]; ok {
+		t.Errorf("oldest snapshot H-1 was kept")
+	}
+	for _, id := range []string{"H-2", "H-3"} {
+		if _, ok := s.GlinesByID[id]; !ok {
+			t.Errorf("%s was pruned, want it kept", id)
+		}
+	}
+}
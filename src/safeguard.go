@@ -0,0 +1,113 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// SafeguardRule is a policy every new or modified gline must respect.
+// A rule only applies to the setters matching Setters (wildcards allowed),
+// or to every setter if it is empty.
+type SafeguardRule struct {
+	Name    string
+	Setters []string
+	// Smallest prefix length allowed, i.e. the largest network a gline
+	// may cover. 0 disables the check.
+	MinPrefixLenV4 int
+	MinPrefixLenV6 int
+	// Regex the reason must match. Empty disables the check.
+	ReasonPattern string
+	// Ask OperServ to remove glines violating this rule.
+	AutoRemove bool
+
+	reasonRegex *regexp.Regexp
+}
+
+type SafeguardConfig struct {
+	Rules []SafeguardRule
+}
+
+// safeguardViolation is published on the event stream as
+// "safeguard.violation".
+type safeguardViolation struct {
+	Rule        string      `json:"rule"`
+	Problems    []string    `json:"problems"`
+	Gline       *eventGline `json:"gline"`
+	AutoRemoved bool        `json:"autoremoved"`
+}
+
+// compileSafeguards compiles the rules' reason patterns. It must be called
+// once before checkSafeguards is used.
+func compileSafeguards(cfg *SafeguardConfig) {
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if r.ReasonPattern != "" {
+			r.reasonRegex = regexp.MustCompile(r.ReasonPattern)
+		}
+	}
+}
+
+func (r *SafeguardRule) appliesTo(setter string) bool {
+	if len(r.Setters) == 0 {
+		return true
+	}
+	for _, m := range r.Setters {
+		if MatchMask(m, setter) {
+			return true
+		}
+	}
+	return false
+}
+
+// problems lists what is wrong with g according to r.
+func (r *SafeguardRule) problems(g *glineData) []string {
+	var list []string
+	ones, bits := g.ipNet.Mask.Size()
+	min := r.MinPrefixLenV4
+	if bits == 128 {
+		min = r.MinPrefixLenV6
+	}
+	if min > 0 && ones < min {
+		list = append(list, fmt.Sprintf("/%d is wider than /%d", ones, min))
+	}
+	if r.reasonRegex != nil && !r.reasonRegex.MatchString(g.Reason()) {
+		list = append(list, "reason doesn't match the required pattern")
+	}
+	return list
+}
+
+// checkSafeguards is a gline observer raising alerts for live glines
+// violating a safeguard rule: loudly in the oper channel and on the event
+// stream. Violating glines are removed through OperServ if the rule says so.
+func (s *serverData) checkSafeguards(c *glineChange) {
+	if !c.Live() || !c.Gline.IsGlineActive() {
+		return
+	}
+	g := c.Gline
+	for i := range s.Config.Safeguards.Rules {
+		r := &s.Config.Safeguards.Rules[i]
+		if !r.appliesTo(c.Setter) {
+			continue
+		}
+		problems := r.problems(g)
+		if len(problems) == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("*** SAFEGUARD VIOLATION (%s) *** %s %s gline on %s: %s. Reason: %s",
+			r.Name, c.Setter, c.Kind, g.Mask(), strings.Join(problems, ", "), g.Reason())
+		log.Println(msg)
+		s.announce(announceSafeguard, msg)
+		if r.AutoRemove {
+			s.requestRemoval(g.Mask(), "safeguard "+r.Name)
+			s.announce(announceRemoval, fmt.Sprintf("Removal of %s requested from %s.", g.Mask(), s.Remover))
+		}
+		s.publish("safeguard.violation", &safeguardViolation{
+			Rule:        r.Name,
+			Problems:    problems,
+			Gline:       newEventGline(g),
+			AutoRemoved: r.AutoRemove,
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package ircglineapi
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeguardRuleProblems(t *testing.T) {
+	cfg := SafeguardConfig{Rules: []SafeguardRule{{
+		Name:           "size",
+		MinPrefixLenV4: 16,
+		MinPrefixLenV6: 32,
+		ReasonPattern:  `\(P\d+\)$`,
+	}}}
+	compileSafeguards(&cfg)
+	r := &cfg.Rules[0]
+	cases := []struct {
+		cidr, reason string
+		want         int
+	}{
+		{"10.0.0.0/16", "drone (P540)", 0},
+		{"10.0.0.0/8", "drone (P540)", 1},
+		{"0.0.0.0/1", "no policy", 2},
+		{"2a01::/16", "drone (P540)", 1},
+		{"2a01:cb00::/32", "drone (P540)", 0},
+	}
+	for _, c := range cases {
+		_, ipNet, _ := net.ParseCIDR(c.cidr)
+		g := newGlineData(*ipNet, "*", "*@"+c.cidr, 0, 0, c.reason, true)
+		if res := r.problems(g); len(res) != c.want {
+			t.Errorf(`problems(%s, %q) = %q. Want %d problems`, c.cidr, c.reason, res, c.want)
+		}
+	}
+}
+
+func TestSafeguardRuleAppliesTo(t *testing.T) {
+	r := &SafeguardRule{Setters: []string{"*.eu.undernet.org"}}
+	if !r.appliesTo("uworld.eu.undernet.org") {
+		t.Errorf(`appliesTo(uworld.eu.undernet.org) = false`)
+	}
+	if r.appliesTo("dronescan.undernet.org") {
+		t.Errorf(`appliesTo(dronescan.undernet.org) = true`)
+	}
+}
+
+func TestSafeguardViolationEvent(t *testing.T) {
+	s := newTestServer(&Configuration{
+		Network:    "undernet",
+		Server:     "hidden.undernet.org",
+		Nick:       "GLS1",
+		Safeguards: SafeguardConfig{Rules: []SafeguardRule{{Name: "size", MinPrefixLenV4: 16}}},
+	})
+	compileSafeguards(&s.Config.Safeguards)
+	events, unsubscribe := s.Events.Subscribe()
+	defer unsubscribe()
+
+	notice := ":hidden.undernet.org NOTICE * :*** Notice -- gnu.undernet.org adding global GLINE for *@0.0.0.0/1, expiring at 4102444800: oops"
+	if err := handleGNOTICE(notice, strings.Split(notice, " "), s); err != nil {
+		t.Fatalf("handleGNOTICE() error: %s", err.Error())
+	}
+	var violation *safeguardViolation
+	for violation == nil {
+		select {
+		case ev := <-events:
+			if ev.Type == "safeguard.violation" {
+				violation = ev.Data.(*safeguardViolation)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no safeguard.violation event")
+		}
+	}
+	if violation.Rule != "size" || violation.Gline.Mask != "*@0.0.0.0/1" || violation.Gline.Setter != "gnu.undernet.org" {
+		t.Errorf("violation = %+v, gline = %+v", violation, violation.Gline)
+	}
+}
@@ -0,0 +1,396 @@
+package ircglineapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/irc-glines-api/client"
+	"github.com/labstack/echo/v4"
+)
+
+// ScheduleConfig controls the removals and modifications of glines
+// scheduled through /api2/schedule.
+type ScheduleConfig struct {
+	// JSON file the scheduled jobs are saved to, so they survive restarts.
+	// Without it, they only live in memory.
+	File string
+	// Command sent to the services bot of the operserv remover to change
+	// the expiration of a gline. It may reference $glinemask, $duration (in
+	// seconds) and $reason, the gline's current reason unless the job gives
+	// one. Modifications can't be scheduled without it, nor with another
+	// remover.
+	ModifyCmd string
+}
+
+// Scheduled job actions.
+const (
+	scheduleRemove = "remove"
+	scheduleModify = "modify"
+)
+
+// scheduledJob is a removal or modification of a gline to run at a given
+// time.
+type scheduledJob = client.ScheduledJob
+
+// scheduleFile is the content of ScheduleConfig.File. LastID is kept so
+// that job numbers aren't reused after a restart.
+type scheduleFile struct {
+	LastID int             `json:"lastid"`
+	Jobs   []*scheduledJob `json:"jobs"`
+}
+
+// scheduler persists the scheduled jobs and runs them when they come due.
+type scheduler struct {
+	mu     sync.Mutex
+	file   string
+	lastID int
+	jobs   map[int]*scheduledJob
+	timers map[int]*time.Timer
+	run    func(*scheduledJob)
+}
+
+func newScheduler(file string) *scheduler {
+	sc := &scheduler{
+		file:   file,
+		jobs:   make(map[int]*scheduledJob),
+		timers: make(map[int]*time.Timer),
+	}
+	if file == "" {
+		return sc
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return sc
+	}
+	if err != nil {
+		log.Fatal("Can't read schedule file:", err)
+	}
+	var content scheduleFile
+	if err := json.Unmarshal(data, &content); err != nil {
+		log.Fatal("schedule file parse error:", err.Error())
+	}
+	sc.lastID = content.LastID
+	for _, job := range content.Jobs {
+		sc.jobs[job.ID] = job
+		if job.ID > sc.lastID {
+			sc.lastID = job.ID
+		}
+	}
+	return sc
+}
+
+// Start arms a timer for every loaded job. Jobs that came due while the
+// bot was down run right away.
+func (sc *scheduler) Start(run func(*scheduledJob)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.run = run
+	for _, job := range sc.jobs {
+		sc.arm(job)
+	}
+}
+
+// arm must be called with sc.mu held.
+func (sc *scheduler) arm(job *scheduledJob) {
+	if sc.run == nil {
+		return
+	}
+	id := job.ID
+	sc.timers[id] = time.AfterFunc(time.Until(time.Unix(job.AtTS, 0)), func() {
+		if job := sc.take(id); job != nil {
+			sc.run(job)
+		}
+	})
+}
+
+// save must be called with sc.mu held.
+func (sc *scheduler) save() error {
+	if sc.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(scheduleFile{LastID: sc.lastID, Jobs: sc.list()}, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := sc.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sc.file)
+}
+
+// list must be called with sc.mu held.
+func (sc *scheduler) list() []*scheduledJob {
+	list := make([]*scheduledJob, 0, len(sc.jobs))
+	for _, job := range sc.jobs {
+		list = append(list, job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].AtTS < list[j].AtTS })
+	return list
+}
+
+// List returns the scheduled jobs, the next one first.
+func (sc *scheduler) List() []*scheduledJob {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.list()
+}
+
+func (sc *scheduler) Add(job *scheduledJob) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.lastID++
+	job.ID = sc.lastID
+	sc.jobs[job.ID] = job
+	if err := sc.save(); err != nil {
+		delete(sc.jobs, job.ID)
+		return err
+	}
+	sc.arm(job)
+	return nil
+}
+
+// Get returns job id, or nil if it isn't scheduled.
+func (sc *scheduler) Get(id int) *scheduledJob {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.jobs[id]
+}
+
+// Cancel removes a job that didn't run yet. It returns nil if there is
+// none with that id.
+func (sc *scheduler) Cancel(id int) *scheduledJob {
+	return sc.take(id)
+}
+
+// take removes and returns job id, if it is still scheduled.
+func (sc *scheduler) take(id int) *scheduledJob {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	job := sc.jobs[id]
+	if job == nil {
+		return nil
+	}
+	delete(sc.jobs, id)
+	if t := sc.timers[id]; t != nil {
+		t.Stop()
+		delete(sc.timers, id)
+	}
+	if err := sc.save(); err != nil {
+		log.Println("scheduler.save():", err.Error())
+	}
+	return job
+}
+
+// Retry schedules job again, at time at.
+func (sc *scheduler) Retry(job *scheduledJob, at time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	job.AtTS = at.Unix()
+	sc.jobs[job.ID] = job
+	if err := sc.save(); err != nil {
+		log.Println("scheduler.save():", err.Error())
+	}
+	sc.arm(job)
+}
+
+// runScheduledJob runs a job that came due. Removals go through the same
+// path as removeGlineApi, including approvals.
+func (s *serverData) runScheduledJob(job *scheduledJob) {
+	if !s.Conn.Connected() {
+		s.Schedule.Retry(job, time.Now().Add(time.Minute))
+		return
+	}
+	switch job.Action {
+	case scheduleRemove:
+		s.announce(announceRemoval, fmt.Sprintf("Scheduled job #%d: removing %s", job.ID, job.Mask))
+		s.removeGline(job.Mask, job.Reason, job.RequestedBy)
+	case scheduleModify:
+		if err := s.modifyGline(job); err != nil {
+			log.Printf("Scheduled job #%d: modification of %s failed: %s\n", job.ID, job.Mask, err.Error())
+			s.announce(announceRemoval, fmt.Sprintf("Scheduled job #%d: modification of %s failed: %s", job.ID, job.Mask, err.Error()))
+			return
+		}
+		s.announce(announceRemoval, fmt.Sprintf("Scheduled job #%d: expiration of %s changed to %s, confirmed.",
+			job.ID, job.Mask, time.Duration(job.Duration)*time.Second))
+	}
+}
+
+// modifyGline sends the modification of job to the services bot of the
+// operserv remover, and waits for the new expiration to be seen on IRC.
+func (s *serverData) modifyGline(job *scheduledJob) error {
+	r, ok := s.Remover.(*operServRemover)
+	if !ok {
+		return fmt.Errorf("not supported by the %s remover", s.Remover)
+	}
+	reason := job.Reason
+	if reason == "" {
+		known := s.knownGlines(job.Mask)
+		if len(known) == 0 {
+			return errors.New("gline not found")
+		}
+		reason = known[0].Reason()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Remover.confirmTimeout())
+	defer cancel()
+	// The services set the expiration from the time they get the command:
+	// allow for the lag.
+	want := time.Now().Unix() + job.Duration
+	wait, done := s.Removals.expectChange(job.Mask, func(c *glineChange) bool {
+		ts := c.Gline.ExpireTS()
+		return c.Kind != glineExpired && c.Gline.IsGlineActive() && ts >= want-60 && ts <= want+60
+	})
+	defer done()
+	r.send(s.modifyCmd(job, reason))
+	if err := confirmRemoval(ctx, wait); err != nil {
+		if errors.Is(err, errRemovalUnconfirmed) {
+			return errors.New("change not seen on IRC")
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *serverData) modifyCmd(job *scheduledJob, reason string) string {
+	cmd := strings.Replace(s.Config.Schedule.ModifyCmd, "$glinemask", job.Mask, -1)
+	cmd = strings.Replace(cmd, "$duration", strconv.FormatInt(job.Duration, 10), -1)
+	return strings.Replace(cmd, "$reason", reason, -1)
+}
+
+type api_schedule_struct struct {
+	Network  string `param:"network"`
+	ID       int    `param:"id"`
+	Action   string `json:"action" form:"action"`
+	Mask     string `json:"mask" form:"mask"`
+	At       string `json:"at" form:"at"` // a time, as accepted by parseAtTime
+	In       string `json:"in" form:"in"` // or a delay, such as "6h"
+	Duration string `json:"duration" form:"duration"`
+	Reason   string `json:"reason" form:"reason"`
+}
+
+// job validates the input and returns the job to schedule.
+func (in *api_schedule_struct) job(s *serverData, now time.Time) (*scheduledJob, error) {
+	job := &scheduledJob{Action: strings.ToLower(in.Action), Mask: in.Mask, Reason: in.Reason, CreatedTS: now.Unix()}
+	if job.Action == "" {
+		job.Action = scheduleRemove
+	}
+	if !validMask(in.Mask) {
+		return nil, errors.New("Invalid mask")
+	}
+	if strings.ContainsAny(in.Reason, "\r\n") {
+		return nil, errors.New("Invalid reason")
+	}
+	switch {
+	case in.At != "" && in.In == "":
+		ts, err := parseAtTime(in.At)
+		if err != nil {
+			return nil, errors.New("Invalid at")
+		}
+		job.AtTS = ts
+	case in.In != "" && in.At == "":
+		d, err := ParseDuration(in.In)
+		if err != nil || d <= 0 {
+			return nil, errors.New("Invalid in")
+		}
+		job.AtTS = now.Add(d).Unix()
+	default:
+		return nil, errors.New("Either at or in is required")
+	}
+	if job.AtTS <= now.Unix() {
+		return nil, errors.New("The time must be in the future")
+	}
+	switch job.Action {
+	case scheduleRemove:
+	case scheduleModify:
+		if s.Config.Schedule.ModifyCmd == "" {
+			return nil, errors.New("Modifications are not configured")
+		}
+		if _, ok := s.Remover.(*operServRemover); !ok {
+			return nil, fmt.Errorf("Modifications need the operserv remover, not %s", s.Remover)
+		}
+		d, err := ParseDuration(in.Duration)
+		if err != nil || d <= 0 {
+			return nil, errors.New("Invalid duration")
+		}
+		job.Duration = int64(d / time.Second)
+	default:
+		return nil, errors.New("Invalid action")
+	}
+	return job, nil
+}
+
+// scheduleApi schedules the removal or modification of a gline.
+func (a *ApiData) scheduleApi(c echo.Context) error {
+	var in api_schedule_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	c.Set(auditTargetKey, in.Mask)
+	job, err := in.job(s, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	job.RequestedBy, _ = c.Get(apiKeyNameKey).(string)
+	if isDryRun(c) {
+		return c.JSON(http.StatusOK, &dryRunResult{DryRun: true, Lines: []string{}, Glines: buildRetGlineDataList(s.knownGlines(job.Mask), false), Job: job})
+	}
+	if err := s.Schedule.Add(job); err != nil {
+		log.Println("scheduleApi():", err.Error())
+		return c.JSON(http.StatusInternalServerError, "Can't save the schedule")
+	}
+	s.announce(announceRemoval, fmt.Sprintf("Scheduled job #%d: %s %s at %s, requested by %s",
+		job.ID, job.Action, job.Mask, formatHistoryTime(job.AtTS), job.RequestedBy))
+	return c.JSON(http.StatusOK, job)
+}
+
+func (a *ApiData) getScheduleApi(c echo.Context) error {
+	var in api_schedule_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	return c.JSON(http.StatusOK, s.Schedule.List())
+}
+
+func (a *ApiData) cancelScheduleApi(c echo.Context) error {
+	var in api_schedule_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if isDryRun(c) {
+		job := s.Schedule.Get(in.ID)
+		if job == nil {
+			return c.JSON(http.StatusNotFound, "Job not found")
+		}
+		c.Set(auditTargetKey, job.Mask)
+		return c.JSON(http.StatusOK, &dryRunResult{DryRun: true, Lines: []string{}, Job: job})
+	}
+	job := s.Schedule.Cancel(in.ID)
+	if job == nil {
+		return c.JSON(http.StatusNotFound, "Job not found")
+	}
+	c.Set(auditTargetKey, job.Mask)
+	s.announce(announceRemoval, fmt.Sprintf("Scheduled job #%d (%s %s) cancelled.", job.ID, job.Action, job.Mask))
+	return c.JSON(http.StatusOK, "Cancelled")
+}
@@ -0,0 +1,136 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedule.json")
+	now := time.Now()
+	sc := newScheduler(file)
+	sc.Add(&scheduledJob{Action: scheduleRemove, Mask: "*@10.96.0.1", AtTS: now.Add(-time.Minute).Unix()})
+	sc.Add(&scheduledJob{Action: scheduleRemove, Mask: "*@10.96.0.2", AtTS: now.Add(time.Hour).Unix()})
+	sc.Add(&scheduledJob{Action: scheduleRemove, Mask: "*@10.96.0.3", AtTS: now.Add(time.Hour).Unix()})
+	if sc.Cancel(3) == nil || sc.Cancel(3) != nil {
+		t.Errorf(`Cancel(3) must return the job once`)
+	}
+
+	// After a restart, the overdue job runs at once and the other waits.
+	sc = newScheduler(file)
+	ran := make(chan *scheduledJob, 2)
+	sc.Start(func(job *scheduledJob) { ran <- job })
+	select {
+	case job := <-ran:
+		if job.ID != 1 || job.Mask != "*@10.96.0.1" {
+			t.Errorf(`ran job %+v. Want #1`, job)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf(`the overdue job didn't run`)
+	}
+	if list := sc.List(); len(list) != 1 || list[0].ID != 2 {
+		t.Errorf(`List() = %+v. Want job #2 only`, list)
+	}
+	job := &scheduledJob{Action: scheduleRemove, Mask: "*@10.96.0.4", AtTS: now.Add(time.Hour).Unix()}
+	sc.Add(job)
+	if job.ID != 4 {
+		t.Errorf(`new job ID = %d. Want 4`, job.ID)
+	}
+	if list := newScheduler(file).List(); len(list) != 2 {
+		t.Errorf(`reloaded %d jobs. Want 2`, len(list))
+	}
+}
+
+func TestScheduleApi(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "schedulenet", Server: "hidden.undernet.org", Nick: "GLS1"})
+	s.NetworkName = "schedulenet"
+	e := newApi(Configuration{ApiKey: "secret"})
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+	var tests = []struct {
+		body string
+		code int
+	}{
+		{"mask=" + url.QueryEscape("*@10.97.0.1") + "&in=6h", http.StatusOK},
+		{"mask=" + url.QueryEscape("*@10.97.0.1") + "&at=2001-01-01", http.StatusBadRequest},
+		{"mask=10.97.0.1&in=6h", http.StatusBadRequest},
+		{"mask=" + url.QueryEscape("*@10.97.0.1"), http.StatusBadRequest},
+		{"mask=" + url.QueryEscape("*@10.97.0.1") + "&in=1h&action=modify&duration=2h", http.StatusBadRequest},
+		{"mask=" + url.QueryEscape("*@10.97.0.1") + "&in=1h&action=ban", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := call("POST", "/api2/schedule/schedulenet", tt.body); w.Code != tt.code {
+			t.Errorf(`schedule %s = %d %s. Want %d`, tt.body, w.Code, w.Body.String(), tt.code)
+		}
+	}
+	s.Config.Schedule.ModifyCmd = "gline $glinemask $duration $reason"
+	w := call("POST", "/api2/schedule/schedulenet", "mask="+url.QueryEscape("*@10.97.0.2")+"&in=1h&action=modify&duration=2h&reason=extended")
+	var job scheduledJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil || job.Duration != 7200 {
+		t.Fatalf(`schedule modify = %d %s. Want a 2h modification`, w.Code, w.Body.String())
+	}
+	if got := s.modifyCmd(&job, job.Reason); got != "gline *@10.97.0.2 7200 extended" {
+		t.Errorf(`modifyCmd() = %q`, got)
+	}
+
+	s.Remover = s.newGlineRemover(RemoverConfig{Type: "gline"})
+	if w := call("POST", "/api2/schedule/schedulenet", "mask="+url.QueryEscape("*@10.97.0.2")+"&in=1h&action=modify&duration=2h"); w.Code != http.StatusBadRequest {
+		t.Errorf(`schedule modify with the gline remover = %d. Want 400`, w.Code)
+	}
+
+	var list []scheduledJob
+	json.Unmarshal(call("GET", "/api2/schedule/schedulenet", "").Body.Bytes(), &list)
+	if len(list) != 2 || list[0].Mask != "*@10.97.0.2" {
+		t.Errorf(`schedule list = %+v. Want 2 jobs, the modification first`, list)
+	}
+	if w := call("DELETE", "/api2/schedule/schedulenet/1", ""); w.Code != http.StatusOK {
+		t.Errorf(`cancel = %d. Want 200`, w.Code)
+	}
+	if w := call("DELETE", "/api2/schedule/schedulenet/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf(`second cancel = %d. Want 404`, w.Code)
+	}
+}
+
+func TestModifyGline(t *testing.T) {
+	s := newTestServer(&Configuration{Network: "modifynet", Server: "hidden.undernet.org", Nick: "GLS2",
+		Schedule: ScheduleConfig{ModifyCmd: "gline $glinemask $duration $reason"}, Remover: RemoverConfig{ConfirmTimeout: 1}})
+	s.Remover = s.newGlineRemover(RemoverConfig{Type: "operserv", Nick: "GlineBot"})
+	now := time.Now().Unix()
+	addTestGline(s, "*@10.97.1.1", now+3600, now, "drone", true)
+
+	if err := s.modifyGline(&scheduledJob{ID: 1, Action: scheduleModify, Mask: "*@10.97.1.2", Duration: 7200}); err == nil {
+		t.Errorf(`modifyGline() of an unknown gline = nil. Want an error`)
+	}
+	go func() {
+		// The services extend the gline, which the bot then sees.
+		time.Sleep(50 * time.Millisecond)
+		addTestGline(s, "*@10.97.1.1", time.Now().Unix()+7200, now, "drone", true)
+	}()
+	if err := s.modifyGline(&scheduledJob{ID: 2, Action: scheduleModify, Mask: "*@10.97.1.1/32", Duration: 7200}); err != nil {
+		t.Errorf(`modifyGline() = %v. Want confirmed`, err)
+	}
+	// Seen, but with another expiration.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		addTestGline(s, "*@10.97.1.1", time.Now().Unix()+600, now, "drone", true)
+	}()
+	if err := s.modifyGline(&scheduledJob{ID: 3, Action: scheduleModify, Mask: "*@10.97.1.1", Duration: 7200}); err == nil {
+		t.Errorf(`modifyGline() with the wrong expiration seen = nil. Want an error`)
+	}
+	s.Remover = s.newGlineRemover(RemoverConfig{Type: "gline"})
+	if err := s.modifyGline(&scheduledJob{ID: 4, Action: scheduleModify, Mask: "*@10.97.1.1", Duration: 7200}); err == nil {
+		t.Errorf(`modifyGline() with the gline remover = nil. Want an error`)
+	}
+}
@@ -0,0 +1,142 @@
+package ircglineapi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// glineFilterParams are the query string filters shared by the search and
+// export endpoints. Empty values match everything.
+type glineFilterParams struct {
+	Active string `query:"active"` // true or false
+	Setter string `query:"setter"` // wildcard mask
+	Reason string `query:"reason"` // regex, case-insensitive
+	Auto   string `query:"auto"`   // true or false
+	Level  string `query:"level"`
+	IP     string `query:"ip"` // IP or CIDR containing the IP parsed from the reason
+	Policy string `query:"policy"`
+	Email  string `query:"email"` // wildcard mask
+	URL    string `query:"url"`   // wildcard mask
+}
+
+// glineFilter selects glines for exports and searches. Zero values match
+// everything.
+type glineFilter struct {
+	Active *bool
+	Setter string
+	Reason *regexp.Regexp
+	Auto   *bool
+	Level  *int
+	IP     *net.IPNet
+	Policy string
+	Email  string
+	URL    string
+}
+
+func parseBoolFilter(name, value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %s", name, value)
+	}
+	return &b, nil
+}
+
+func newGlineFilter(p glineFilterParams) (*glineFilter, error) {
+	f := &glineFilter{Setter: p.Setter, Policy: p.Policy, Email: p.Email, URL: p.URL}
+	var err error
+	if f.Active, err = parseBoolFilter("active", p.Active); err != nil {
+		return nil, err
+	}
+	if f.Auto, err = parseBoolFilter("auto", p.Auto); err != nil {
+		return nil, err
+	}
+	if p.Reason != "" {
+		if f.Reason, err = regexp.Compile("(?i)" + p.Reason); err != nil {
+			return nil, fmt.Errorf("invalid reason regex: %s", err.Error())
+		}
+	}
+	if p.Level != "" {
+		level, err := strconv.Atoi(p.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level value: %s", p.Level)
+		}
+		f.Level = &level
+	}
+	if p.IP != "" {
+		if _, f.IP, err = net.ParseCIDR(AddCidrToIP(p.IP)); err != nil {
+			return nil, fmt.Errorf("invalid ip value: %s", p.IP)
+		}
+	}
+	return f, nil
+}
+
+func (f *glineFilter) Match(g *glineData) bool {
+	info := g.Info()
+	switch {
+	case f.Active != nil && g.IsGlineActive() != *f.Active:
+		return false
+	case f.Setter != "" && !MatchMask(f.Setter, g.Setter()):
+		return false
+	case f.Reason != nil && !f.Reason.MatchString(g.Reason()):
+		return false
+	case f.Auto != nil && info.Auto != *f.Auto:
+		return false
+	case f.Level != nil && (info.Level == nil || *info.Level != *f.Level):
+		return false
+	case f.IP != nil && (info.IP == "" || !f.IP.Contains(net.ParseIP(info.IP))):
+		return false
+	case f.Policy != "" && !strings.EqualFold(f.Policy, info.Policy):
+		return false
+	case f.Email != "" && !MatchMask(f.Email, info.Email):
+		return false
+	case f.URL != "" && !MatchMask(f.URL, info.URL):
+		return false
+	}
+	return true
+}
+
+type api_search_struct struct {
+	Network string `param:"network"`
+	Limit   int    `query:"limit"`
+	Filter  glineFilterParams
+}
+
+// searchApi returns the glines matching the filters, up to ?limit= of them
+// (100 by default, 1000 at most).
+func (a *ApiData) searchApi(c echo.Context) error {
+	var in api_search_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	f, err := newGlineFilter(in.Filter)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if in.Limit <= 0 {
+		in.Limit = 100
+	} else if in.Limit > 1000 {
+		in.Limit = 1000
+	}
+	entries := make([]*glineData, 0)
+	s.forEachGline(func(g *glineData) bool {
+		if f.Match(g) {
+			entries = append(entries, g)
+		}
+		return len(entries) < in.Limit
+	})
+	list := s.lookupRetGlineDataList(entries, false, parseAcceptLanguage(c.Request().Header.Get("Accept-Language")))
+	return c.JSON(http.StatusOK, &list)
+}
@@ -0,0 +1,501 @@
+package ircglineapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hiddn/cidranger"
+	"github.com/labstack/echo/v4"
+)
+
+// WatchlistConfig says where watchlists are kept and where alerts go when
+// a gline lands on a watched range.
+type WatchlistConfig struct {
+	// JSON file the watchlists are loaded from and saved to. Without it,
+	// watchlists only live in memory.
+	File string
+	// Channel receiving alerts. Defaults to the first configured channel.
+	AlertChannel string
+	// URL receiving a JSON POST for every alert.
+	Webhook string
+	Email   AlertEmailConfig
+}
+
+// AlertEmailConfig is the SMTP setup used to email alerts. Emails are only
+// sent when Host and To are set.
+type AlertEmailConfig struct {
+	Host string
+	Port int
+	User string
+	Pass string
+	From string
+	To   []string
+}
+
+// watchlists holds named lists of CIDRs, plus a ranger over all of them to
+// find overlapping glines quickly.
+type watchlists struct {
+	mu     sync.RWMutex
+	file   string
+	lists  map[string][]string
+	ranger cidranger.Ranger
+}
+
+// watchEntry is a ranger entry for one watched CIDR, which may belong to
+// several lists.
+type watchEntry struct {
+	ipNet net.IPNet
+	names []string
+}
+
+func (w *watchEntry) Network() net.IPNet {
+	return w.ipNet
+}
+
+// watchHit is one watched range overlapping a gline.
+type watchHit struct {
+	List string `json:"list"`
+	CIDR string `json:"cidr"`
+}
+
+func newWatchlists(file string) *watchlists {
+	w := &watchlists{
+		file:   file,
+		lists:  make(map[string][]string),
+		ranger: cidranger.NewPCTrieRanger(),
+	}
+	if file == "" {
+		return w
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return w
+	}
+	if err != nil {
+		log.Fatal("Can't read watchlists file:", err)
+	}
+	if err := json.Unmarshal(data, &w.lists); err != nil {
+		log.Fatal("watchlists file parse error:", err.Error())
+	}
+	w.rebuild()
+	return w
+}
+
+// normalizeCIDR validates cidr, accepting bare IPs, and returns it in
+// canonical network form.
+func normalizeCIDR(cidr string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(AddCidrToIP(cidr))
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR: %s", cidr)
+	}
+	return ipNet.String(), nil
+}
+
+// rebuild recreates the ranger from w.lists. The caller must hold w.mu.
+func (w *watchlists) rebuild() {
+	entries := make(map[string]*watchEntry)
+	for name, cidrs := range w.lists {
+		for _, cidr := range cidrs {
+			e, ok := entries[cidr]
+			if !ok {
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					log.Printf("Watchlist %s: skipping invalid CIDR %s\n", name, cidr)
+					continue
+				}
+				e = &watchEntry{ipNet: *ipNet}
+				entries[cidr] = e
+			}
+			e.names = append(e.names, name)
+		}
+	}
+	w.ranger = cidranger.NewPCTrieRanger()
+	for _, e := range entries {
+		sort.Strings(e.names)
+		w.ranger.Insert(e)
+	}
+}
+
+// save writes the watchlists to w.file. The caller must hold w.mu.
+func (w *watchlists) save() error {
+	if w.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(w.lists, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := w.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.file)
+}
+
+// Lists returns a copy of all watchlists.
+func (w *watchlists) Lists() map[string][]string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ret := make(map[string][]string, len(w.lists))
+	for name, cidrs := range w.lists {
+		ret[name] = append([]string(nil), cidrs...)
+	}
+	return ret
+}
+
+// Add adds CIDRs to the named list, creating it if needed.
+func (w *watchlists) Add(name string, cidrs ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list, err := w.withAdded(name, cidrs)
+	if err != nil {
+		return err
+	}
+	w.lists[name] = list
+	w.rebuild()
+	return w.save()
+}
+
+// Remove removes a CIDR from the named list, or the whole list if cidr is
+// empty. It returns false if there was nothing to remove.
+func (w *watchlists) Remove(name, cidr string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	kept, removed, err := w.withRemoved(name, cidr)
+	if err != nil || !removed {
+		return false, err
+	}
+	if len(kept) == 0 {
+		delete(w.lists, name)
+	} else {
+		w.lists[name] = kept
+	}
+	w.rebuild()
+	return true, w.save()
+}
+
+// PreviewAdd returns the named list as Add would leave it.
+func (w *watchlists) PreviewAdd(name string, cidrs ...string) ([]string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.withAdded(name, cidrs)
+}
+
+// PreviewRemove returns the named list as Remove would leave it, and
+// whether there is anything to remove.
+func (w *watchlists) PreviewRemove(name, cidr string) ([]string, bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.withRemoved(name, cidr)
+}
+
+// withAdded returns a copy of the named list with cidrs added. The caller
+// must hold w.mu.
+func (w *watchlists) withAdded(name string, cidrs []string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("watchlist name is empty")
+	}
+	list := append([]string(nil), w.lists[name]...)
+	for _, c := range cidrs {
+		n, err := normalizeCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, existing := range list {
+			if existing == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, n)
+		}
+	}
+	return list, nil
+}
+
+// withRemoved returns a copy of the named list without cidr, empty if cidr
+// is, and whether anything is removed. The caller must hold w.mu.
+func (w *watchlists) withRemoved(name, cidr string) ([]string, bool, error) {
+	list, ok := w.lists[name]
+	if !ok {
+		return nil, false, nil
+	}
+	if cidr == "" {
+		return nil, true, nil
+	}
+	n, err := normalizeCIDR(cidr)
+	if err != nil {
+		return nil, false, err
+	}
+	kept := make([]string, 0, len(list))
+	for _, c := range list {
+		if c != n {
+			kept = append(kept, c)
+		}
+	}
+	return kept, len(kept) < len(list), nil
+}
+
+func (w *watchlists) Match(ipNet net.IPNet) []watchHit {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	entries, err := w.ranger.CoveringOrCoveredNetworks(ipNet)
+	if err != nil {
+		return nil
+	}
+	hits := make([]watchHit, 0, len(entries))
+	for _, e := range entries {
+		we := e.(*watchEntry)
+		for _, name := range we.names {
+			hits = append(hits, watchHit{List: name, CIDR: we.ipNet.String()})
+		}
+	}
+	return hits
+}
+
+// watchlistAlert is the payload posted to the webhook.
+type watchlistAlert struct {
+	Network  string     `json:"network"`
+	Hits     []watchHit `json:"hits"`
+	Mask     string     `json:"mask"`
+	Setter   string     `json:"setter"`
+	Reason   string     `json:"reason"`
+	ExpireTS int64      `json:"expirets"`
+	ID       string     `json:"id,omitempty"`
+}
+
+// checkWatchlists is a gline observer alerting when a live notice creates
+// or activates a gline overlapping a watched range. Glines (re)learnt from
+// the /GLINE listing on connect don't alert, or every reconnect would.
+func (s *serverData) checkWatchlists(c *glineChange) {
+	if !c.Live() || !c.Activated() {
+		return
+	}
+	hits := s.Watchlists.Match(c.Gline.ipNet)
+	if len(hits) == 0 {
+		return
+	}
+	g := c.Gline
+	alert := &watchlistAlert{
+		Network:  s.Config.Network,
+		Hits:     hits,
+		Mask:     g.Mask(),
+		Setter:   c.Setter,
+		Reason:   g.Reason(),
+		ExpireTS: g.ExpireTS(),
+		ID:       g.ID(),
+	}
+	ranges := make([]string, 0, len(hits))
+	for _, h := range hits {
+		ranges = append(ranges, fmt.Sprintf("%s (%s)", h.CIDR, h.List))
+	}
+	msg := fmt.Sprintf("Watchlist alert: gline on %s overlaps %s, set by %s, expires in %s: %s",
+		g.Mask(), strings.Join(ranges, ", "), c.Setter, time.Duration(g.SecondsUntilExpiration())*time.Second, g.Reason())
+	log.Println(msg)
+
+	s.publish("watchlist.hit", alert)
+	cfg := s.Config.Watchlists
+	s.announce(announceWatchlist, msg)
+	if cfg.Webhook != "" {
+		go func() {
+			if err := postJSON(cfg.Webhook, alert); err != nil {
+				log.Printf("Watchlist webhook failed: %s\n", err.Error())
+			}
+		}()
+	}
+	if cfg.Email.Host != "" && len(cfg.Email.To) > 0 {
+		go func() {
+			if err := sendAlertEmail(cfg.Email, "Watchlist alert: "+g.Mask(), msg); err != nil {
+				log.Printf("Watchlist email failed: %s\n", err.Error())
+			}
+		}()
+	}
+}
+
+// postJSON POSTs v as JSON to url and fails on any non-2xx status.
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func sendAlertEmail(cfg AlertEmailConfig, subject, body string) error {
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if cfg.User != "" {
+		auth = smtp.PlainAuth("", cfg.User, cfg.Pass, cfg.Host)
+	}
+	msg := alertEmailMessage(cfg, subject, body, time.Now())
+	return smtp.SendMail(fmt.Sprintf("%s:%d", cfg.Host, port), auth, cfg.From, cfg.To, []byte(msg))
+}
+
+// alertEmailMessage returns the alert email, headers included, sent at now.
+func alertEmailMessage(cfg AlertEmailConfig, subject, body string, now time.Time) string {
+	return fmt.Sprintf("From: %s\r\nTo: %s\r\nDate: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		cfg.From, strings.Join(cfg.To, ", "), now.Format(time.RFC1123Z), subject, body)
+}
+
+func init() {
+	registerBotCommand(&botCommand{
+		Name:       "watch",
+		Args:       "list [name] | add <name> <cidr> | del <name> [cidr]",
+		Help:       "Manage the CIDR watchlists alerting when a gline lands on them.",
+		MinArgs:    1,
+		MaxArgs:    3,
+		Privileged: true,
+		Run:        (*serverData).cmdWatch,
+	})
+}
+
+func (s *serverData) cmdWatch(inv *invocation, args []string) {
+	switch strings.ToLower(args[0]) {
+	case "list":
+		lists := s.Watchlists.Lists()
+		if len(args) > 1 {
+			cidrs, ok := lists[args[1]]
+			if !ok {
+				s.reply(inv, fmt.Sprintf("No watchlist named %s", args[1]))
+				return
+			}
+			s.reply(inv, fmt.Sprintf("%s: %s", args[1], strings.Join(cidrs, " ")))
+			return
+		}
+		if len(lists) == 0 {
+			s.reply(inv, "No watchlists.")
+			return
+		}
+		names := make([]string, 0, len(lists))
+		for name, cidrs := range lists {
+			names = append(names, fmt.Sprintf("%s (%d)", name, len(cidrs)))
+		}
+		sort.Strings(names)
+		s.reply(inv, "Watchlists: "+strings.Join(names, ", "))
+	case "add":
+		if len(args) < 3 {
+			s.reply(inv, "Syntax: !watch add <name> <cidr>")
+			return
+		}
+		if err := s.Watchlists.Add(args[1], strings.Fields(args[2])...); err != nil {
+			s.reply(inv, fmt.Sprintf("Error: %s", err.Error()))
+			return
+		}
+		s.reply(inv, fmt.Sprintf("Added %s to watchlist %s.", args[2], args[1]))
+	case "del":
+		if len(args) < 2 {
+			s.reply(inv, "Syntax: !watch del <name> [cidr]")
+			return
+		}
+		cidr := ""
+		if len(args) > 2 {
+			cidr = args[2]
+		}
+		removed, err := s.Watchlists.Remove(args[1], cidr)
+		if err != nil {
+			s.reply(inv, fmt.Sprintf("Error: %s", err.Error()))
+			return
+		}
+		if !removed {
+			s.reply(inv, "Nothing to remove.")
+			return
+		}
+		s.reply(inv, "Removed.")
+	default:
+		s.reply(inv, "Syntax: !watch list [name] | add <name> <cidr> | del <name> [cidr]")
+	}
+}
+
+type api_watchlist_struct struct {
+	Network string   `param:"network"`
+	Name    string   `param:"name"`
+	CIDR    string   `query:"cidr"`
+	CIDRs   []string `json:"cidrs"`
+}
+
+func (a *ApiData) getWatchlistsApi(c echo.Context) error {
+	var in api_watchlist_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	return c.JSON(http.StatusOK, s.Watchlists.Lists())
+}
+
+func (a *ApiData) addWatchlistApi(c echo.Context) error {
+	var in api_watchlist_struct
+	if err := c.Bind(&in); err != nil || len(in.CIDRs) == 0 {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	if isDryRun(c) {
+		list, err := s.Watchlists.PreviewAdd(in.Name, in.CIDRs...)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		return c.JSON(http.StatusOK, &dryRunResult{DryRun: true, Lines: []string{}, Watchlist: list})
+	}
+	if err := s.Watchlists.Add(in.Name, in.CIDRs...); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, s.Watchlists.Lists()[in.Name])
+}
+
+func (a *ApiData) removeWatchlistApi(c echo.Context) error {
+	var in api_watchlist_struct
+	if err := c.Bind(&in); err != nil {
+		return c.JSON(http.StatusBadRequest, "bad request")
+	}
+	s := servers.GetServerInfosByNetwork(in.Network)
+	if s == nil {
+		return c.JSON(http.StatusNotFound, "Network not found")
+	}
+	var removed bool
+	var err error
+	var kept []string
+	if isDryRun(c) {
+		kept, removed, err = s.Watchlists.PreviewRemove(in.Name, in.CIDR)
+	} else {
+		removed, err = s.Watchlists.Remove(in.Name, in.CIDR)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if !removed {
+		return c.JSON(http.StatusNotFound, "Watchlist or CIDR not found")
+	}
+	if isDryRun(c) {
+		return c.JSON(http.StatusOK, &dryRunResult{DryRun: true, Lines: []string{}, Watchlist: kept})
+	}
+	return c.JSON(http.StatusOK, "Removed")
+}
@@ -0,0 +1,108 @@
+package ircglineapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchlistsAddMatchRemove(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "watchlists.json")
+	w := newWatchlists(file)
+	if err := w.Add("servers", "192.0.2.10", "2001:db8::/32"); err != nil {
+		t.Fatalf("Add() error: %s", err.Error())
+	}
+	if err := w.Add("partners", "192.0.2.0/24"); err != nil {
+		t.Fatalf("Add() error: %s", err.Error())
+	}
+	if err := w.Add("bad", "192.0.2.0/33"); err == nil {
+		t.Errorf("Add() accepted an invalid CIDR")
+	}
+
+	_, glined, _ := net.ParseCIDR("192.0.0.0/16")
+	if hits := w.Match(*glined); len(hits) != 2 {
+		t.Errorf("Match(192.0.0.0/16) = %+v. Want both lists", hits)
+	}
+	_, glined, _ = net.ParseCIDR("192.0.2.77/32")
+	if hits := w.Match(*glined); len(hits) != 1 || hits[0].List != "partners" {
+		t.Errorf("Match(192.0.2.77/32) = %+v. Want only partners", hits)
+	}
+
+	// Reloading from disk must give the same lists.
+	reloaded := newWatchlists(file)
+	if got := reloaded.Lists()["servers"]; len(got) != 2 || got[0] != "192.0.2.10/32" {
+		t.Errorf("reloaded servers list = %q", got)
+	}
+
+	if removed, _ := w.Remove("partners", "192.0.2.0/24"); !removed {
+		t.Errorf("Remove(partners, 192.0.2.0/24) = false")
+	}
+	if _, ok := w.Lists()["partners"]; ok {
+		t.Errorf("emptied watchlist was kept")
+	}
+	if removed, _ := w.Remove("nosuchlist", ""); removed {
+		t.Errorf("Remove(nosuchlist) = true")
+	}
+}
+
+func TestWatchlistAlertWebhook(t *testing.T) {
+	received := make(chan watchlistAlert, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var alert watchlistAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		received <- alert
+	}))
+	defer hook.Close()
+
+	s := newTestServer(&Configuration{
+		Network:    "undernet",
+		Server:     "hidden.undernet.org",
+		Nick:       "GLW1",
+		Watchlists: WatchlistConfig{Webhook: hook.URL},
+	})
+	s.Watchlists.Add("uni", "198.51.100.0/24")
+
+	// Listing on connect: no alert.
+	addTestGline(s, "*@198.51.100.1", time.Now().Unix()+3600, time.Now().Unix(), "burst", true)
+	notice := ":hidden.undernet.org NOTICE * :*** Notice -- gnu.undernet.org adding global GLINE for *@198.51.100.0/25, expiring at 4102444800: [0] proxy"
+	if err := handleGNOTICE(notice, strings.Split(notice, " "), s); err != nil {
+		t.Fatalf("handleGNOTICE() error: %s", err.Error())
+	}
+	select {
+	case alert := <-received:
+		if alert.Mask != "*@198.51.100.0/25" || alert.Setter != "gnu.undernet.org" || alert.Reason != "[0] proxy" {
+			t.Errorf("webhook alert = %+v", alert)
+		}
+		if len(alert.Hits) != 1 || alert.Hits[0].List != "uni" {
+			t.Errorf("webhook alert hits = %+v. Want the uni list", alert.Hits)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no webhook alert received")
+	}
+	select {
+	case alert := <-received:
+		t.Errorf("unexpected second alert: %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAlertEmailMessage(t *testing.T) {
+	cfg := AlertEmailConfig{From: "noreply@undernet.org", To: []string{"a@undernet.org", "b@undernet.org"}}
+	now := time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+	msg, err := mail.ReadMessage(strings.NewReader(alertEmailMessage(cfg, "alert", "hello", now)))
+	if err != nil {
+		t.Fatalf(`alertEmailMessage() = invalid message: %s`, err.Error())
+	}
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(now) {
+		t.Errorf(`alertEmailMessage() Date = %v, %v. Want %v`, date, err, now)
+	}
+	if to := msg.Header.Get("To"); to != "a@undernet.org, b@undernet.org" || msg.Header.Get("Subject") != "alert" {
+		t.Errorf(`alertEmailMessage() headers = %v`, msg.Header)
+	}
+}